@@ -26,18 +26,20 @@ import (
 	"compress/zlib"
 	"errors"
 	"fmt"
+	"image"
 	"io"
 	"log"
 	"net/http"
 	"os"
-	"sort"
-	"strings"
 	"time"
 
 	"github.com/davecgh/go-spew/spew"
 	"github.com/gorilla/mux"
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/WebChunk/render"
 	"github.com/maxsupermanhd/go-vmc/v764/nbt"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
 	_ "github.com/maxsupermanhd/go-vmc/v764/save/region"
 )
 
@@ -123,63 +125,103 @@ func apiAddChunkHandler(w http.ResponseWriter, r *http.Request) (int, string) {
 		}
 	}
 	dim, err := s.GetDimension(wname, dname)
-	if err != nil {
+	if err != nil && !errors.Is(err, chunkStorage.ErrNoDim) {
 		return http.StatusInternalServerError, fmt.Sprintf("Error checking dim: %s", err)
 	}
 	if dim == nil {
-		err = s.AddDimension(wname, chunkStorage.SDim{
+		dim = &chunkStorage.SDim{
 			Name:       dname,
 			World:      wname,
 			CreatedAt:  time.Now(),
 			ModifiedAt: time.Now(),
-			Data:       chunkStorage.GuessDimTypeFromName(dname),
-		})
+			Data:       chunkStorage.GuessDimTypeFromChunk(dname, col),
+		}
+		err = s.AddDimension(wname, *dim)
 		if err != nil {
 			return http.StatusInternalServerError, fmt.Sprintf("Error creating dim: %s", err)
 		}
-		if dim == nil {
-			return http.StatusInternalServerError, "Tried to create dim but got nil"
-		}
 	}
 	err = s.AddChunkRaw(wname, dname, int(col.XPos), int(col.ZPos), body)
 	if err != nil {
 		log.Printf("Failed to submit chunk %v:%v world %v dimension %v: %v", col.XPos, col.ZPos, wname, dname, err.Error())
 		return http.StatusInternalServerError, fmt.Sprintf("Failed to add chunk to storage: %s", err.Error())
 	}
+	tileChanges.MarkChunk(wname, dname, int(col.XPos), int(col.ZPos))
 	log.Print("Submitted chunk ", col.XPos, col.ZPos, " world ", wname, " dimension ", dname)
 	dTTYPE := r.Header.Get("WebChunk-DrawTTYPE")
 	if dTTYPE != "" {
-		var dPainter chunkPainterFunc
+		var rr *render.ChunkRenderer
 		if dTTYPE == "default" {
-			for i := range ttypes {
-				if i.IsDefault {
-					dTTYPE = i.Name
-					drawTTYPE := ttypes[i]
-					_, dPainter = drawTTYPE(s)
-					break
-				}
-			}
+			rr = renderers.GetDefault()
 		} else {
-			for i := range ttypes {
-				if i.Name == dTTYPE {
-					drawTTYPE := ttypes[i]
-					_, dPainter = drawTTYPE(s)
-					break
-				}
-			}
+			rr = renderers.Get(dTTYPE)
 		}
-		if dPainter == nil {
+		if rr == nil {
 			return http.StatusBadRequest, "Requested terrain type not found!"
 		}
+		if rr.History || rr.NeedsQuery {
+			return http.StatusBadRequest, "Requested terrain type needs parameters, use tile requests to draw it"
+		}
+		drawnAt := time.Now()
+		img, loc, err := renderSubmittedChunk(s, wname, dname, &dim.Data, rr, col)
+		if err != nil {
+			return http.StatusInternalServerError, fmt.Sprintf("Failed to draw chunk: %s", err.Error())
+		}
+		if img == nil {
+			return http.StatusNoContent, ""
+		}
 		w.WriteHeader(http.StatusOK)
-		img := dPainter(col)
 		writeImage(w, "png", img)
-		imageCacheSave(img, wname, dname, dTTYPE, 0, int(col.XPos), int(col.ZPos))
+		imageCacheSaveLoc(img, loc, drawnAt)
 		return -1, ""
 	}
 	return http.StatusOK, fmt.Sprintf("Chunk %d:%d of %s:%s submitted. Thank you for your contribution!\n", col.XPos, col.ZPos, wname, dname)
 }
 
+// Submitted chunk is drawn same as in tiles, data renderer needs
+// (neighbours, dimension, dates) is taken from storage it was saved to.
+// Neighbours are optional, without them biome tint is blended only
+// inside the chunk. Returned location is the one image is cached at.
+func renderSubmittedChunk(s chunkStorage.ChunkStorage, wname, dname string, dim *save.DimensionType, rr *render.ChunkRenderer, col *save.Chunk) (*image.RGBA, primitives.ImageLocation, error) {
+	cx, cz := int(col.XPos), int(col.ZPos)
+	loc := normalizeImageLocation(primitives.ImageLocation{
+		World:     wname,
+		Dimension: dname,
+		Variant:   rr.Name,
+		X:         cx,
+		Z:         cz,
+	})
+	if rr.RenderTile != nil {
+		return rr.RenderTile(0, cx, cz, 16), loc, nil
+	}
+	opts := renderOptionsFromLocation(loc)
+	cc, err := render.GetRegionData(s, wname, dname, rr.DataNeeds, opts, cx, cz, cx+1, cz+1)
+	if err == nil {
+		for _, c := range cc {
+			if c.X == cx && c.Z == cz {
+				return renderChunkSafe(rr, c), loc, nil
+			}
+		}
+	}
+	if rr.ChunkCount || rr.ModDate {
+		return nil, loc, err
+	}
+	if err != nil {
+		log.Printf("Drawing submitted chunk %d:%d without neighbours: %s", cx, cz, err.Error())
+	}
+	return renderChunkSafe(rr, render.PositionedChunkData{
+		X: cx,
+		Z: cz,
+		Data: &render.RegionChunkData{
+			DimensionName: dname,
+			Dimension:     dim,
+			Chunk:         col,
+			Count:         1,
+			Options:       opts,
+		},
+	}), loc, nil
+}
+
 func apiAddRegionHandler(w http.ResponseWriter, _ *http.Request) {
 	w.WriteHeader(http.StatusNotImplemented)
 	// params := mux.Vars(r)
@@ -353,10 +395,5 @@ func apiStorageAdd(_ http.ResponseWriter, r *http.Request) (int, string) {
 }
 
 func apiListRenderers(_ http.ResponseWriter, _ *http.Request) (int, string) {
-	keys := make([]ttype, 0, len(ttypes))
-	for t := range ttypes {
-		keys = append(keys, t)
-	}
-	sort.Slice(keys, func(i, j int) bool { return strings.Compare(keys[i].Name, keys[j].Name) > 0 })
-	return marshalOrFail(200, keys)
+	return marshalOrFail(200, renderers.List())
 }
@@ -0,0 +1,175 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image/color"
+
+	"github.com/maxsupermanhd/WebChunk/data/biomes"
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+type tintKind int
+
+const (
+	tintNone tintKind = iota
+	tintGrass
+	tintFoliage
+	tintWater
+)
+
+func blockTintKind(state block.StateID) tintKind {
+	switch block.StateList[state].(type) {
+	case block.GrassBlock, block.Grass, block.TallGrass, block.Fern, block.LargeFern, block.PottedFern, block.SugarCane:
+		return tintGrass
+	case block.OakLeaves, block.JungleLeaves, block.AcaciaLeaves, block.DarkOakLeaves, block.MangroveLeaves, block.Vine:
+		return tintFoliage
+	case block.Water, block.WaterCauldron:
+		return tintWater
+	default:
+		return tintNone
+	}
+}
+
+type biomeTintKey struct {
+	kind    tintKind
+	x, y, z int
+}
+
+// Grass, foliage and water colors from biomes of a chunk and it's neighbors,
+// colors are averaged over a square of 2*radius+1 blocks like vanilla biome blend does.
+// Missing neighbors are skipped so without them colors are blended only inside the chunk.
+// Coordinates are relative to the center chunk, same as in areaBlocks.
+type biomeTinter struct {
+	chunks [3][3]map[int]*level.PaletteContainer[level.BiomesState]
+	radius int
+	cache  map[biomeTintKey]color.RGBA64
+}
+
+func newChunkBiomes(c *save.Chunk) map[int]*level.PaletteContainer[level.BiomesState] {
+	if c == nil {
+		return nil
+	}
+	ret := map[int]*level.PaletteContainer[level.BiomesState]{}
+	for i := range c.Sections {
+		s := &c.Sections[i]
+		if len(s.Biomes.Palette) == 0 {
+			continue
+		}
+		ret[int(s.Y)] = prepareSectionBiomes(s)
+	}
+	return ret
+}
+
+func newBiomeTinter(d render.ChunkData, radius int) *biomeTinter {
+	if radius < 0 {
+		radius = 0
+	}
+	return &biomeTinter{
+		chunks: [3][3]map[int]*level.PaletteContainer[level.BiomesState]{
+			{newChunkBiomes(d.GetWestNorth()), newChunkBiomes(d.GetWest()), newChunkBiomes(d.GetSouthWest())},
+			{newChunkBiomes(d.GetNorth()), newChunkBiomes(d.Get()), newChunkBiomes(d.GetSouth())},
+			{newChunkBiomes(d.GetNorthEast()), newChunkBiomes(d.GetEast()), newChunkBiomes(d.GetEastSouth())},
+		},
+		radius: radius,
+		cache:  map[biomeTintKey]color.RGBA64{},
+	}
+}
+
+// blend radius is taken from config
+func renderBiomeTinter(d render.ChunkData) *biomeTinter {
+	return newBiomeTinter(d, cfg.GetDSInt(2, "biome_blend"))
+}
+
+func (t *biomeTinter) biome(x, y, z int) (int, bool) {
+	cx, cz := floorDiv(x, 16)+1, floorDiv(z, 16)+1
+	if cx < 0 || cx > 2 || cz < 0 || cz > 2 {
+		return 0, false
+	}
+	s, ok := t.chunks[cx][cz][floorDiv(y, 16)]
+	if !ok {
+		return 0, false
+	}
+	lx, ly, lz := x-(cx-1)*16, y-floorDiv(y, 16)*16, z-(cz-1)*16
+	return int(s.Get((ly/4)*4*4 + (lz/4)*4 + lx/4)), true
+}
+
+func (t *biomeTinter) color(kind tintKind, x, y, z int) (color.RGBA64, bool) {
+	k := biomeTintKey{kind, x, y, z}
+	if c, ok := t.cache[k]; ok {
+		return c, c.A != 0
+	}
+	var r, g, b, n uint32
+	for dx := -t.radius; dx <= t.radius; dx++ {
+		for dz := -t.radius; dz <= t.radius; dz++ {
+			id, ok := t.biome(x+dx, y, z+dz)
+			if !ok {
+				continue
+			}
+			climate := biomes.GetClimate(id)
+			var c color.RGBA
+			switch kind {
+			case tintGrass:
+				c = climate.GrassColor()
+			case tintFoliage:
+				c = climate.FoliageColor()
+			case tintWater:
+				c = climate.WaterColor()
+			}
+			r += uint32(c.R)
+			g += uint32(c.G)
+			b += uint32(c.B)
+			n++
+		}
+	}
+	ret := color.RGBA64{}
+	if n > 0 {
+		ret = color.RGBA64{
+			R: uint16(r / n * 257),
+			G: uint16(g / n * 257),
+			B: uint16(b / n * 257),
+			A: 0xFFFF,
+		}
+	}
+	t.cache[k] = ret
+	return ret, n > 0
+}
+
+// Replaces palette color of tinted blocks keeping it's transparency,
+// nil tinter keeps the palette color
+func (t *biomeTinter) apply(state block.StateID, c color.RGBA64, x, y, z int) color.RGBA64 {
+	if t == nil {
+		return c
+	}
+	kind := blockTintKind(state)
+	if kind == tintNone {
+		return c
+	}
+	tc, ok := t.color(kind, x, y, z)
+	if !ok {
+		return c
+	}
+	tc.A = c.A
+	return tc
+}
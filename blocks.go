@@ -0,0 +1,130 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+var airState = block.ToStateID[block.Air{}]
+
+// decoded block states of a chunk for random access
+type chunkBlocks struct {
+	sections map[int]*level.PaletteContainer[block.StateID]
+	minY     int // lowest block y in stored sections
+	maxY     int // one above highest block y in stored sections
+}
+
+func newChunkBlocks(c *save.Chunk) *chunkBlocks {
+	if c == nil {
+		return nil
+	}
+	ret := &chunkBlocks{
+		sections: map[int]*level.PaletteContainer[block.StateID]{},
+	}
+	first := true
+	for i := range c.Sections {
+		s := &c.Sections[i]
+		if len(s.BlockStates.Palette) == 0 {
+			continue
+		}
+		states := prepareSectionBlockstates(s)
+		if states == nil {
+			continue
+		}
+		if len(s.BlockStates.Palette) == 1 && isAirState(states.Get(0)) {
+			continue
+		}
+		sy := int(s.Y)
+		ret.sections[sy] = states
+		if first || sy*16 < ret.minY {
+			ret.minY = sy * 16
+		}
+		if first || sy*16+16 > ret.maxY {
+			ret.maxY = sy*16 + 16
+		}
+		first = false
+	}
+	return ret
+}
+
+// x and z are local to the chunk, y is absolute
+func (b *chunkBlocks) get(x, y, z int) block.StateID {
+	if b == nil {
+		return airState
+	}
+	s, ok := b.sections[floorDiv(y, 16)]
+	if !ok {
+		return airState
+	}
+	return s.Get((y-floorDiv(y, 16)*16)*16*16 + z*16 + x)
+}
+
+// chunk with it's 8 neighbors, coordinates are relative to the center one
+type areaBlocks struct {
+	chunks [3][3]*chunkBlocks
+}
+
+func newAreaBlocks(d render.ChunkData) *areaBlocks {
+	return &areaBlocks{
+		chunks: [3][3]*chunkBlocks{
+			{newChunkBlocks(d.GetWestNorth()), newChunkBlocks(d.GetWest()), newChunkBlocks(d.GetSouthWest())},
+			{newChunkBlocks(d.GetNorth()), newChunkBlocks(d.Get()), newChunkBlocks(d.GetSouth())},
+			{newChunkBlocks(d.GetNorthEast()), newChunkBlocks(d.GetEast()), newChunkBlocks(d.GetEastSouth())},
+		},
+	}
+}
+
+// x and z in range of -16..31, everything outside is air
+func (a *areaBlocks) get(x, y, z int) block.StateID {
+	cx, cz := floorDiv(x, 16)+1, floorDiv(z, 16)+1
+	if cx < 0 || cx > 2 || cz < 0 || cz > 2 {
+		return airState
+	}
+	return a.chunks[cx][cz].get(x-(cx-1)*16, y, z-(cz-1)*16)
+}
+
+// highest block y (exclusive) in any of the chunks
+func (a *areaBlocks) maxY() (int, bool) {
+	ret, found := 0, false
+	for _, r := range a.chunks {
+		for _, c := range r {
+			if c == nil || len(c.sections) == 0 {
+				continue
+			}
+			if !found || c.maxY > ret {
+				ret = c.maxY
+				found = true
+			}
+		}
+	}
+	return ret, found
+}
+
+func floorDiv(a, b int) int {
+	if a < 0 && a%b != 0 {
+		return a/b - 1
+	}
+	return a / b
+}
@@ -0,0 +1,288 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"log"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+)
+
+const blockQueryMaxPredicates = 32
+
+var (
+	searchChunkColor  = color.NRGBA{255, 0, 255, 64}
+	searchColumnColor = color.NRGBA{255, 0, 255, 255}
+)
+
+// Block state predicate, for example minecraft:furnace[lit=true,facing=north|south],
+// namespace defaults to minecraft and every listed property has to match one of values
+type blockPredicate struct {
+	id    string
+	props map[string][]string
+}
+
+func parseBlockPredicate(s string) (blockPredicate, error) {
+	ret := blockPredicate{props: map[string][]string{}}
+	name, props := s, ""
+	if i := strings.IndexByte(s, '['); i >= 0 {
+		if !strings.HasSuffix(s, "]") {
+			return ret, fmt.Errorf("unterminated properties of %q", s)
+		}
+		name, props = s[:i], s[i+1:len(s)-1]
+	}
+	if !strings.Contains(name, ":") {
+		name = "minecraft:" + name
+	}
+	b, ok := block.FromID[name]
+	if !ok {
+		return ret, fmt.Errorf("unknown block %q", name)
+	}
+	ret.id = name
+	known := blockStateProperties(b)
+	for _, e := range strings.Split(props, ",") {
+		if e == "" {
+			continue
+		}
+		kv := strings.SplitN(e, "=", 2)
+		if len(kv) != 2 || kv[1] == "" {
+			return ret, fmt.Errorf("bad property %q of %q", e, name)
+		}
+		if _, ok := known[kv[0]]; !ok {
+			return ret, fmt.Errorf("block %q has no property %q", name, kv[0])
+		}
+		values := strings.Split(kv[1], "|")
+		sort.Strings(values)
+		ret.props[kv[0]] = values
+	}
+	return ret, nil
+}
+
+func (p blockPredicate) String() string {
+	if len(p.props) == 0 {
+		return p.id
+	}
+	props := []string{}
+	for k, v := range p.props {
+		props = append(props, k+"="+strings.Join(v, "|"))
+	}
+	sort.Strings(props)
+	return p.id + "[" + strings.Join(props, ",") + "]"
+}
+
+func (p blockPredicate) matches(b block.Block) bool {
+	if b.ID() != p.id {
+		return false
+	}
+	if len(p.props) == 0 {
+		return true
+	}
+	props := blockStateProperties(b)
+	for k, values := range p.props {
+		v, ok := props[k]
+		if !ok {
+			return false
+		}
+		found := false
+		for _, vv := range values {
+			if vv == v {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// property names are taken from nbt tags, values are in the same text form as in saves
+func blockStateProperties(b block.Block) map[string]string {
+	ret := map[string]string{}
+	v := reflect.ValueOf(b)
+	if v.Kind() != reflect.Struct {
+		return ret
+	}
+	for i := 0; i < v.NumField(); i++ {
+		name := v.Type().Field(i).Tag.Get("nbt")
+		if name == "" {
+			continue
+		}
+		m, ok := v.Field(i).Interface().(encoding.TextMarshaler)
+		if !ok {
+			continue
+		}
+		t, err := m.MarshalText()
+		if err != nil {
+			continue
+		}
+		ret[name] = string(t)
+	}
+	return ret
+}
+
+func parseBlockQuery(q string) ([]blockPredicate, error) {
+	fields := strings.Fields(q)
+	if len(fields) == 0 {
+		return nil, errors.New("empty block query")
+	}
+	if len(fields) > blockQueryMaxPredicates {
+		return nil, fmt.Errorf("block query has more than %d predicates", blockQueryMaxPredicates)
+	}
+	ret := []blockPredicate{}
+	for _, f := range fields {
+		p, err := parseBlockPredicate(f)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, p)
+	}
+	return ret, nil
+}
+
+// Sorted and deduplicated so same searches share cached images
+func normalizeBlockQuery(q string) (string, error) {
+	predicates, err := parseBlockQuery(q)
+	if err != nil {
+		return "", err
+	}
+	s := []string{}
+	for _, p := range predicates {
+		s = append(s, p.String())
+	}
+	sort.Strings(s)
+	ret := []string{}
+	for i, v := range s {
+		if i == 0 || s[i-1] != v {
+			ret = append(ret, v)
+		}
+	}
+	return strings.Join(ret, " "), nil
+}
+
+// Predicates resolved to block states
+type blockQuery struct {
+	ids    map[string]bool
+	states []bool
+}
+
+var (
+	blockQueries     = map[string]*blockQuery{}
+	blockQueriesLock sync.Mutex
+)
+
+func getBlockQuery(q string) (*blockQuery, error) {
+	blockQueriesLock.Lock()
+	defer blockQueriesLock.Unlock()
+	if ret, ok := blockQueries[q]; ok {
+		return ret, nil
+	}
+	predicates, err := parseBlockQuery(q)
+	if err != nil {
+		return nil, err
+	}
+	ret := &blockQuery{
+		ids:    map[string]bool{},
+		states: make([]bool, len(block.StateList)),
+	}
+	for _, p := range predicates {
+		ret.ids[p.id] = true
+	}
+	for i, b := range block.StateList {
+		if !ret.ids[b.ID()] {
+			continue
+		}
+		for _, p := range predicates {
+			if p.matches(b) {
+				ret.states[i] = true
+				break
+			}
+		}
+	}
+	if len(blockQueries) > 256 {
+		blockQueries = map[string]*blockQuery{}
+	}
+	blockQueries[q] = ret
+	return ret, nil
+}
+
+// Chunks containing matching blocks are tinted and columns with them are highlighted
+func drawChunkBlockSearch(d render.ChunkData) *image.RGBA {
+	t := time.Now()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	chunk := d.Get()
+	if chunk == nil {
+		return img
+	}
+	q, err := getBlockQuery(d.GetOptions().Query)
+	if err != nil {
+		log.Printf("Bad block query %q: %s", d.GetOptions().Query, err.Error())
+		return img
+	}
+	var columns [16 * 16]bool
+	found := false
+	for i := range chunk.Sections {
+		s := &chunk.Sections[i]
+		inPalette := false
+		for _, p := range s.BlockStates.Palette {
+			if q.ids[p.Name] {
+				inPalette = true
+				break
+			}
+		}
+		if !inPalette {
+			continue
+		}
+		states := prepareSectionBlockstates(s)
+		if states == nil {
+			continue
+		}
+		for j := 0; j < 16*16*16; j++ {
+			if q.states[states.Get(j)] {
+				columns[j%(16*16)] = true
+				found = true
+			}
+		}
+	}
+	if found {
+		draw.Draw(img, img.Bounds(), &image.Uniform{searchChunkColor}, image.Point{}, draw.Src)
+		for i, c := range columns {
+			if c {
+				img.Set(i%16, i/16, searchColumnColor)
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "blocksearch")
+	return img
+}
@@ -0,0 +1,83 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"math"
+
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// Highest block y top-down renderers look at in each column (indexed z*16+x),
+// nil when there is no limit.
+// Explicit Y cutoff wins, otherwise dimensions with a ceiling (nether) have it skipped:
+// rendering starts from the first air gap below logical height or the top of the chunk.
+func chunkColumnTops(chunk *save.Chunk, dt *save.DimensionType, opts render.Options) []int {
+	if opts.HasY {
+		ret := make([]int, 16*16)
+		for i := range ret {
+			ret[i] = opts.Y
+		}
+		return ret
+	}
+	if chunk == nil || dt == nil || !dt.HasCeiling {
+		return nil
+	}
+	b := newChunkBlocks(chunk)
+	if len(b.sections) == 0 {
+		return nil
+	}
+	start := b.maxY - 1
+	if logicalTop := int(dt.MinY+dt.LogicalHeight) - 1; dt.LogicalHeight > 0 && logicalTop < start {
+		start = logicalTop
+	}
+	ret := make([]int, 16*16)
+	for i := range ret {
+		y := start
+		for y >= b.minY && !isAirState(b.get(i%16, y, i/16)) {
+			y--
+		}
+		// no gap at all, nothing to skip
+		if y < b.minY {
+			y = start
+		}
+		ret[i] = y
+	}
+	return ret
+}
+
+func columnTopsMax(tops []int) int {
+	if tops == nil {
+		return math.MaxInt32
+	}
+	ret := tops[0]
+	for _, t := range tops {
+		if t > ret {
+			ret = t
+		}
+	}
+	return ret
+}
+
+func aboveColumnTop(tops []int, i, y int) bool {
+	return tops != nil && y > tops[i]
+}
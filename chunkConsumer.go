@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"compress/gzip"
 	"errors"
+	"fmt"
 	"log"
 	"strings"
 	"time"
@@ -19,6 +20,20 @@ func chunkConsumer(exitchan <-chan struct{}) {
 		select {
 		case <-exitchan:
 			return
+		case l := <-levelChannel:
+			if l.Server == "" {
+				continue
+			}
+			w, s, err := getProxiedWorld(l.Server)
+			if err != nil {
+				log.Printf("Failed to get world for level data from [%v] by [%v]: %s", l.Server, l.Username, err.Error())
+				continue
+			}
+			l.Apply(&w.Data)
+			err = s.SetWorldData(w.Name, w.Data)
+			if err != nil && !errors.Is(err, chunkStorage.ErrNotImplemented) {
+				log.Printf("Failed to save level data of [%v]: %s", w.Name, err.Error())
+			}
 		case r := <-chunkChannel:
 			if r.Dimension == "" || r.Server == "" {
 				log.Printf("Got chunk [%v](%v) from [%v] by [%v] with empty params, DROPPING", r.Pos, r.Dimension, r.Server, r.Username)
@@ -26,51 +41,41 @@ func chunkConsumer(exitchan <-chan struct{}) {
 			}
 			log.Printf("Got chunk %v %#v from [%v] by [%v] (%2d s) (%3d be)", r.Pos, r.Dimension, r.Server, r.Username, len(r.Data.Sections), len(r.Data.BlockEntity))
 			r.Dimension = strings.TrimPrefix(r.Dimension, "minecraft:")
-			w, s, err := chunkStorage.GetWorldStorage(storages, r.Server)
+			w, s, err := getProxiedWorld(r.Server)
 			if err != nil {
-				log.Println("Failed to lookup world storage: ", err)
-				break
+				log.Printf("Failed to get world for chunk [%v] from [%v] by [%v]: %s", r.Pos, r.Server, r.Username, err.Error())
+				continue
 			}
 			var d *chunkStorage.SDim
-			if w == nil || s == nil {
-				pref := cfg.GetDSString("", "preferred_storage")
-				s = findCapableStorage(storages, pref)
-				if s == nil {
-					log.Printf("Failed to find storage that has world [%s], named [%s] or has ability to add chunks, chunk [%v] from [%v] by [%v] is LOST.", r.Server, pref, r.Pos, r.Server, r.Username)
-					continue
-				}
-				w = &chunkStorage.SWorld{
-					Name:       r.Server,
-					Alias:      "",
-					IP:         r.Server,
-					CreatedAt:  time.Now(),
-					ModifiedAt: time.Now(),
-					Data:       chunkStorage.CreateDefaultLevelData(r.Server),
-				}
-				err = s.AddWorld(*w)
-				if err != nil {
-					log.Printf("Failed to add world: %s", err.Error())
-					continue
-				}
-			}
 			d, err = s.GetDimension(w.Name, r.Dimension)
 			if err != nil && !errors.Is(err, chunkStorage.ErrNoDim) {
 				log.Printf("Failed to get dim: %s", err.Error())
 				continue
 			}
+			dimType := r.DimensionType
+			if dimType.Height == 0 {
+				dimType = chunkStorage.GuessDimTypeFromName(r.Dimension)
+			}
 			if d == nil {
 				d = &chunkStorage.SDim{
 					Name:       r.Dimension,
 					World:      w.Name,
 					CreatedAt:  time.Now(),
 					ModifiedAt: time.Now(),
-					Data:       chunkStorage.GuessDimTypeFromName(r.Dimension),
+					Data:       dimType,
 				}
 				err = s.AddDimension(w.Name, *d)
 				if err != nil {
 					log.Printf("Failed to add dim: %s", err.Error())
 					continue
 				}
+			} else if r.DimensionType.Height != 0 && d.Data != r.DimensionType {
+				err = s.SetDimensionData(w.Name, d.Name, r.DimensionType)
+				if err != nil && !errors.Is(err, chunkStorage.ErrNotImplemented) {
+					log.Printf("Failed to update dim data: %s", err.Error())
+				} else if err == nil {
+					d.Data = r.DimensionType
+				}
 			}
 			if d == nil {
 				log.Println("d is nill")
@@ -145,13 +150,38 @@ func chunkConsumer(exitchan <-chan struct{}) {
 			err = s.AddChunkRaw(w.Name, d.Name, int(r.Pos[0]), int(r.Pos[1]), chunkBytes.Bytes())
 			if err != nil {
 				log.Printf("Failed to save chunk: %s", err.Error())
+				continue
 			}
-			if cfg.GetDSBool(true, "render_received") {
-				go func() {
-					i := drawChunk(&data)
-					imageCacheSave(i, w.Name, d.Name, "terrain", 0, int(r.Pos[0]), int(r.Pos[1]))
-				}()
-			}
+			// cached tiles showing it are drawn again by changed tiles renderer
+			tileChanges.MarkChunk(w.Name, d.Name, int(r.Pos[0]), int(r.Pos[1]))
 		}
 	}
 }
+
+func getProxiedWorld(server string) (*chunkStorage.SWorld, chunkStorage.ChunkStorage, error) {
+	w, s, err := chunkStorage.GetWorldStorage(storages, server)
+	if err != nil {
+		return nil, nil, err
+	}
+	if w != nil && s != nil {
+		return w, s, nil
+	}
+	pref := cfg.GetDSString("", "preferred_storage")
+	s = findCapableStorage(storages, pref)
+	if s == nil {
+		return nil, nil, fmt.Errorf("no storage has world [%s], named [%s] or has ability to add chunks", server, pref)
+	}
+	w = &chunkStorage.SWorld{
+		Name:       server,
+		Alias:      "",
+		IP:         server,
+		CreatedAt:  time.Now(),
+		ModifiedAt: time.Now(),
+		Data:       chunkStorage.CreateDefaultLevelData(server),
+	}
+	err = s.AddWorld(*w)
+	if err != nil {
+		return nil, nil, fmt.Errorf("adding world: %w", err)
+	}
+	return w, s, nil
+}
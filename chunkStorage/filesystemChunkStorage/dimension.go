@@ -23,6 +23,7 @@ package filesystemChunkStorage
 import (
 	"os"
 	"path"
+	"sort"
 	"time"
 
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
@@ -47,6 +48,37 @@ func dirModtime(p string) time.Time {
 	return time.Time{}
 }
 
+var defaultDimensionFolders = map[string]string{
+	"overworld":  "",
+	"the_nether": "DIM-1",
+	"the_end":    "DIM1",
+}
+
+func getDimensionPath(wpath, dname string) string {
+	if f, ok := defaultDimensionFolders[dname]; ok {
+		return path.Join(wpath, f)
+	}
+	return path.Join(wpath, "dimensions", "webchunk", dname)
+}
+
+// dimension types are stored in world meta because custom
+// dimensions have nowhere else to keep them, for default ones
+// it is an override that falls back to vanilla values
+func makeDim(wpath, wname, dname string, meta *worldMeta) *chunkStorage.SDim {
+	data := chunkStorage.GuessDimTypeFromName(dname)
+	if meta != nil {
+		if dt, ok := meta.Dimensions[dname]; ok {
+			data = dt
+		}
+	}
+	return &chunkStorage.SDim{
+		Name:       dname,
+		World:      wname,
+		ModifiedAt: dirModtime(getDimensionPath(wpath, dname)),
+		Data:       data,
+	}
+}
+
 func (s *FilesystemChunkStorage) ListWorldDimensions(wname string) ([]chunkStorage.SDim, error) {
 	wpath := path.Join(s.Root, wname)
 	dims := []chunkStorage.SDim{}
@@ -54,25 +86,22 @@ func (s *FilesystemChunkStorage) ListWorldDimensions(wname string) ([]chunkStora
 	if err != nil || !winfo.IsDir() {
 		return dims, chunkStorage.ErrNoWorld
 	}
-	dims = []chunkStorage.SDim{
-		{
-			Name:       "overworld",
-			World:      wname,
-			Data:       save.DefaultDimensionsTypes["minecraft:overworld"],
-			ModifiedAt: winfo.ModTime(),
-		},
-		{
-			Name:       "the_nether",
-			World:      wname,
-			Data:       save.DefaultDimensionsTypes["minecraft:the_nether"],
-			ModifiedAt: dirModtime(path.Join(wpath, "DIM-1")),
-		},
-		{
-			Name:       "the_end",
-			World:      wname,
-			Data:       save.DefaultDimensionsTypes["minecraft:the_end"],
-			ModifiedAt: dirModtime(path.Join(wpath, "DIM1")),
-		},
+	meta, err := readWorldMeta(wpath)
+	if err != nil {
+		return dims, err
+	}
+	for _, dname := range []string{"overworld", "the_nether", "the_end"} {
+		dims = append(dims, *makeDim(wpath, wname, dname, meta))
+	}
+	custom := []string{}
+	for dname := range meta.Dimensions {
+		if _, ok := defaultDimensionFolders[dname]; !ok {
+			custom = append(custom, dname)
+		}
+	}
+	sort.Strings(custom)
+	for _, dname := range custom {
+		dims = append(dims, *makeDim(wpath, wname, dname, meta))
 	}
 	return dims, nil
 }
@@ -94,7 +123,27 @@ func (s *FilesystemChunkStorage) ListDimensions() ([]chunkStorage.SDim, error) {
 }
 
 func (s *FilesystemChunkStorage) AddDimension(wname string, dim chunkStorage.SDim) error {
-	return chunkStorage.ErrNotImplemented
+	wpath := path.Join(s.Root, wname)
+	winfo, err := os.Stat(wpath)
+	if err != nil || !winfo.IsDir() {
+		return chunkStorage.ErrNoWorld
+	}
+	meta, err := readWorldMeta(wpath)
+	if err != nil {
+		return err
+	}
+	if _, ok := meta.Dimensions[dim.Name]; ok {
+		return chunkStorage.ErrAlreadyExists
+	}
+	err = os.MkdirAll(path.Join(getDimensionPath(wpath, dim.Name), "region"), 0777)
+	if err != nil {
+		return err
+	}
+	if meta.Dimensions == nil {
+		meta.Dimensions = map[string]save.DimensionType{}
+	}
+	meta.Dimensions[dim.Name] = dim.Data
+	return writeWorldMeta(wpath, *meta)
 }
 
 func (s *FilesystemChunkStorage) GetDimension(wname, dname string) (*chunkStorage.SDim, error) {
@@ -103,33 +152,32 @@ func (s *FilesystemChunkStorage) GetDimension(wname, dname string) (*chunkStorag
 	if err != nil || !winfo.IsDir() {
 		return nil, chunkStorage.ErrNoWorld
 	}
-	switch dname {
-	case "overworld":
-		return &chunkStorage.SDim{
-			Name:       "overworld",
-			World:      wname,
-			ModifiedAt: winfo.ModTime(),
-			Data:       save.DefaultDimensionsTypes["overworld"],
-		}, nil
-	case "the_nether":
-		return &chunkStorage.SDim{
-			Name:       "the_nether",
-			World:      wname,
-			ModifiedAt: dirModtime(path.Join(wpath, "DIM-1")),
-			Data:       save.DefaultDimensionsTypes["the_nether"],
-		}, nil
-	case "the_end":
-		return &chunkStorage.SDim{
-			Name:       "the_end",
-			World:      wname,
-			ModifiedAt: dirModtime(path.Join(wpath, "DIM1")),
-			Data:       save.DefaultDimensionsTypes["the_end"],
-		}, nil
-	default:
+	meta, err := readWorldMeta(wpath)
+	if err != nil {
+		return nil, err
+	}
+	_, isDefault := defaultDimensionFolders[dname]
+	_, isKnown := meta.Dimensions[dname]
+	if !isDefault && !isKnown {
 		return nil, chunkStorage.ErrNoDim
 	}
+	return makeDim(wpath, wname, dname, meta), nil
 }
 
 func (s *FilesystemChunkStorage) SetDimensionData(wname, dname string, data save.DimensionType) error {
-	return chunkStorage.ErrNotImplemented
+	wpath := path.Join(s.Root, wname)
+	meta, err := readWorldMeta(wpath)
+	if err != nil {
+		return err
+	}
+	_, isDefault := defaultDimensionFolders[dname]
+	_, isKnown := meta.Dimensions[dname]
+	if !isDefault && !isKnown {
+		return chunkStorage.ErrNoDim
+	}
+	if meta.Dimensions == nil {
+		meta.Dimensions = map[string]save.DimensionType{}
+	}
+	meta.Dimensions[dname] = data
+	return writeWorldMeta(wpath, *meta)
 }
@@ -284,7 +284,11 @@ func (s *FilesystemChunkStorage) regionWorker(loc regionLocator, ch <-chan regio
 		switch r.op {
 		case regionRouterGetModDate:
 			x, z := region.In(r.cx1, r.cz1)
-			r.result <- reg.Timestamps[x][z]
+			if reg.ExistSector(x, z) && reg.Timestamps[x][z] != 0 {
+				r.result <- time.Unix(int64(reg.Timestamps[x][z]), 0)
+			} else {
+				r.result <- nil
+			}
 		case regionRouterSetChunk:
 			x, z := region.In(r.cx1, r.cz1)
 			err = reg.WriteSector(x, z, r.data)
@@ -407,11 +411,100 @@ func (s *FilesystemChunkStorage) GetChunkModDate(wname, dname string, cx, cz int
 			return nil, v
 		case time.Time:
 			return &v, nil
+		case nil:
+			return nil, nil
 		}
 	}
 	return nil, errors.New("no response from region worker")
 }
 
+func (s *FilesystemChunkStorage) GetChunksModDateRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	cx0, cz0, cx1, cz1 = normalizeCoords(cx0, cz0, cx1, cz1)
+	ret := []chunkStorage.ChunkData{}
+	for x := cx0; x < cx1; x++ {
+		for z := cz0; z < cz1; z++ {
+			t, err := s.GetChunkModDate(wname, dname, x, z)
+			if err != nil {
+				// requests queued while worker found no region file
+				if errors.Is(err, os.ErrNotExist) {
+					continue
+				}
+				return ret, err
+			}
+			if t != nil {
+				ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: *t})
+			}
+		}
+	}
+	return ret, nil
+}
+
+func (s *FilesystemChunkStorage) ListChunksModDate(wname, dname string) ([]chunkStorage.ChunkData, error) {
+	dirloc := s.getRegionFolder(regionLocator{
+		world:     wname,
+		dimension: dname,
+	})
+	d, err := os.ReadDir(dirloc)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return []chunkStorage.ChunkData{}, nil
+		}
+		return nil, err
+	}
+	ret := []chunkStorage.ChunkData{}
+	for _, i := range d {
+		var rx, rz int
+		if i.IsDir() || !ExtractRegionPath(i.Name(), &rx, &rz) {
+			continue
+		}
+		cc, err := ListRegionChunks(path.Join(dirloc, i.Name()), rx, rz)
+		if err != nil {
+			return ret, err
+		}
+		ret = append(ret, cc...)
+	}
+	return ret, nil
+}
+
+// reads header of the region file, chunks without timestamp get file modification time
+func ListRegionChunks(fname string, rx, rz int) ([]chunkStorage.ChunkData, error) {
+	f, err := os.Open(fname)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	defer f.Close()
+	var header struct {
+		Offsets    [32 * 32]int32
+		Timestamps [32 * 32]int32
+	}
+	err = binary.Read(f, binary.BigEndian, &header)
+	if err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	st, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	ret := []chunkStorage.ChunkData{}
+	for i := 0; i < 32*32; i++ {
+		if header.Offsets[i] == 0 {
+			continue
+		}
+		t := st.ModTime()
+		if header.Timestamps[i] != 0 {
+			t = time.Unix(int64(header.Timestamps[i]), 0)
+		}
+		ret = append(ret, chunkStorage.ChunkData{X: rx*32 + i%32, Z: rz*32 + i/32, Data: t})
+	}
+	return ret, nil
+}
+
 func (s *FilesystemChunkStorage) GetChunk(wname, dname string, cx, cz int) (*save.Chunk, error) {
 	d, err := s.GetChunkRaw(wname, dname, cx, cz)
 	if err != nil {
@@ -572,6 +665,14 @@ collectLoop:
 	return ret, errs
 }
 
+func (s *FilesystemChunkStorage) GetChunksRegionAt(wname, dname string, cx0, cz0, cx1, cz1 int, at time.Time) ([]chunkStorage.ChunkData, error) {
+	return nil, chunkStorage.ErrNotImplemented
+}
+
+func (s *FilesystemChunkStorage) PruneChunksHistory(wname, dname string, before time.Time) (int64, error) {
+	return 0, chunkStorage.ErrNotImplemented
+}
+
 func (s *FilesystemChunkStorage) GetChunksCountRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
 	cx0, cz0, cx1, cz1 = normalizeCoords(cx0, cz0, cx1, cz1)
 	resCount := (cx1 - cx0) * (cz1 - cz0)
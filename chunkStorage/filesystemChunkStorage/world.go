@@ -140,8 +140,9 @@ func (s *FilesystemChunkStorage) GetWorldPath(wname string) string {
 }
 
 type worldMeta struct {
-	Alias string
-	IP    string
+	Alias      string
+	IP         string
+	Dimensions map[string]save.DimensionType `json:",omitempty"`
 }
 
 func getWorldDirMetaPath(wdir string) string {
@@ -204,6 +204,48 @@ func (s *PostgresChunkStorage) GetChunksRegionRaw(wname, dname string, cx0, cz0,
 	return c, perr
 }
 
+func (s *PostgresChunkStorage) GetChunksRegionAt(wname, dname string, cx0, cz0, cx1, cz1 int, at time.Time) ([]chunkStorage.ChunkData, error) {
+	ret := []chunkStorage.ChunkData{}
+	var dimID int
+	err := s.DBPool.QueryRow(context.Background(), `SELECT id FROM dimensions WHERE world = $1 and name = $2`, wname, dname).Scan(&dimID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
+		}
+		return ret, err
+	}
+	rows, err := s.DBPool.Query(context.Background(), `
+		select distinct on (x, z) x, z, data
+		from chunks
+		where x >= $1 AND z >= $2 AND x < $3 AND z < $4 AND dim = $5 AND created_at <= $6
+		order by x, z, created_at desc
+		`, cx0, cz0, cx1, cz1, dimID, at)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
+		} else {
+			log.Print(err.Error())
+		}
+		return ret, err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var d []byte
+		var x, z int
+		err = rows.Scan(&x, &z, &d)
+		if err != nil {
+			return ret, err
+		}
+		c, err := chunkStorage.ConvFlexibleNBTtoSave(d)
+		if err != nil {
+			log.Printf("Failed to parse chunk data (%s), chunk x%d z%d", err.Error(), x, z)
+			continue
+		}
+		ret = append(ret, chunkStorage.ChunkData{X: x, Z: z, Data: *c})
+	}
+	return ret, rows.Err()
+}
+
 func (s *PostgresChunkStorage) GetChunksCountRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
 	cc := []chunkStorage.ChunkData{}
 	rows, derr := s.DBPool.Query(context.Background(), `
@@ -255,6 +297,27 @@ func (s *PostgresChunkStorage) AddChunkRaw(wname, dname string, cx, cz int, dat
 	return err
 }
 
+func (s *PostgresChunkStorage) PruneChunksHistory(wname, dname string, before time.Time) (int64, error) {
+	var dimID int
+	err := s.DBPool.QueryRow(context.Background(), `SELECT id FROM dimensions WHERE world = $1 and name = $2`, wname, dname).Scan(&dimID)
+	if err != nil {
+		if err == pgx.ErrNoRows {
+			err = nil
+		}
+		return 0, err
+	}
+	tag, err := s.DBPool.Exec(context.Background(), `
+		delete from chunks c
+		where c.dim = $1 AND c.created_at < $2 AND exists (
+			select 1 from chunks n
+			where n.dim = c.dim AND n.x = c.x AND n.z = c.z AND n.created_at > c.created_at AND n.created_at <= $2)
+		`, dimID, before)
+	if err != nil {
+		return 0, err
+	}
+	return tag.RowsAffected(), nil
+}
+
 func (s *PostgresChunkStorage) GetChunkModDate(wname, dname string, cx, cz int) (*time.Time, error) {
 	var t time.Time
 	err := s.DBPool.QueryRow(context.Background(), `
@@ -270,3 +333,66 @@ func (s *PostgresChunkStorage) GetChunkModDate(wname, dname string, cx, cz int)
 	}
 	return &t, nil
 }
+
+func (s *PostgresChunkStorage) GetChunksModDateRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error) {
+	cc := []chunkStorage.ChunkData{}
+	rows, derr := s.DBPool.Query(context.Background(), `
+	select
+	x, z, max(created_at)
+	from chunks
+	where dim = (select dimensions.id from dimensions
+				 where dimensions.world = $5 and dimensions.name = $6) AND
+		  x >= $1 AND z >= $2 AND x < $3 AND z < $4
+	group by x, z
+		`, cx0, cz0, cx1, cz1, wname, dname)
+	if derr != nil {
+		if derr == pgx.ErrNoRows {
+			derr = nil
+		} else {
+			log.Print(derr.Error())
+		}
+		return cc, derr
+	}
+	for rows.Next() {
+		var x, z int
+		var t time.Time
+		derr := rows.Scan(&x, &z, &t)
+		if derr != nil {
+			log.Print(derr.Error())
+			continue
+		}
+		cc = append(cc, chunkStorage.ChunkData{X: x, Z: z, Data: t})
+	}
+	return cc, rows.Err()
+}
+
+func (s *PostgresChunkStorage) ListChunksModDate(wname, dname string) ([]chunkStorage.ChunkData, error) {
+	cc := []chunkStorage.ChunkData{}
+	rows, derr := s.DBPool.Query(context.Background(), `
+	select
+	x, z, max(created_at)
+	from chunks
+	where dim = (select dimensions.id from dimensions
+				 where dimensions.world = $1 and dimensions.name = $2)
+	group by x, z
+		`, wname, dname)
+	if derr != nil {
+		if derr == pgx.ErrNoRows {
+			derr = nil
+		} else {
+			log.Print(derr.Error())
+		}
+		return cc, derr
+	}
+	for rows.Next() {
+		var x, z int
+		var t time.Time
+		derr := rows.Scan(&x, &z, &t)
+		if derr != nil {
+			log.Print(derr.Error())
+			continue
+		}
+		cc = append(cc, chunkStorage.ChunkData{X: x, Z: z, Data: t})
+	}
+	return cc, rows.Err()
+}
@@ -87,7 +87,7 @@ func (s *PostgresChunkStorage) SetWorldIP(wname, ip string) error {
 }
 
 func (s *PostgresChunkStorage) SetWorldData(wname string, data save.LevelData) error {
-	_, derr := s.DBPool.Exec(context.Background(), `UPDATE worlds SET data = $1 WHERE name = $2`, wname, data)
+	_, derr := s.DBPool.Exec(context.Background(), `UPDATE worlds SET data = $1 WHERE name = $2`, data, wname)
 	return derr
 
 }
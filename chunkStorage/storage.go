@@ -101,8 +101,20 @@ type ChunkStorage interface {
 	GetChunksRegionRaw(wname, dname string, cx0, cz0, cx1, cz1 int) ([]ChunkData, error)
 	// Warning, chunk data array may be real big!
 	GetChunksCountRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]ChunkData, error)
+	// Latest versions stored at or before given time, only for storages that can preserve old chunks
+	// Warning, chunk data array may be real big!
+	GetChunksRegionAt(wname, dname string, cx0, cz0, cx1, cz1 int, at time.Time) ([]ChunkData, error)
+	// Deletes versions stored before given time that have newer version also stored at or before it,
+	// so state at given time and latest version of chunk are always kept,
+	// only for storages that can preserve old chunks
+	PruneChunksHistory(wname, dname string, before time.Time) (int64, error)
 
 	GetChunkModDate(wname, dname string, cx, cz int) (*time.Time, error)
+	// Data is time.Time of the latest stored version
+	GetChunksModDateRegion(wname, dname string, cx0, cz0, cx1, cz1 int) ([]ChunkData, error)
+	// All stored chunks of the dimension, Data is time.Time of the latest stored version
+	// Warning, chunk data array may be real big!
+	ListChunksModDate(wname, dname string) ([]ChunkData, error)
 
 	Close() error
 }
@@ -343,3 +355,27 @@ func GuessDimTypeFromName(dname string) save.DimensionType {
 		return dt
 	}
 }
+
+// Name alone does not tell how tall custom dimensions are,
+// extend guessed height range to fit all sections of the chunk
+func GuessDimTypeFromChunk(dname string, c *save.Chunk) save.DimensionType {
+	dt := GuessDimTypeFromName(dname)
+	if c == nil {
+		return dt
+	}
+	lo, hi := dt.MinY, dt.MinY+dt.Height
+	for _, s := range c.Sections {
+		if int32(s.Y)*16 < lo {
+			lo = int32(s.Y) * 16
+		}
+		if int32(s.Y)*16+16 > hi {
+			hi = int32(s.Y)*16 + 16
+		}
+	}
+	dt.MinY = lo
+	dt.Height = hi - lo
+	if dt.LogicalHeight > dt.Height {
+		dt.LogicalHeight = dt.Height
+	}
+	return dt
+}
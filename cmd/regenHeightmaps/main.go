@@ -1,3 +1,5 @@
+// Deprecated: regenheightmaps job of the web server does the same through the
+// chunk storage, this tool is not maintained and works only on region files.
 package main
 
 import (
@@ -5,6 +7,7 @@ import (
 	"errors"
 	"flag"
 	"log"
+	"math/bits"
 	"os"
 	"os/signal"
 	"path"
@@ -20,10 +23,13 @@ import (
 )
 
 var (
-	fspath = flag.String("path", "../../storage/constspawn/constantiam.net/region/", "Path to region folder")
+	fspath    = flag.String("path", "../../storage/constspawn/constantiam.net/region/", "Path to region folder")
+	dimMinY   = flag.Int("miny", -64, "Lowest Y of the dimension (min_y of dimension type)")
+	dimHeight = flag.Int("height", 384, "Height of the dimension (height of dimension type)")
 )
 
 func main() {
+	flag.Parse()
 
 	// var c save.Chunk
 	// // b, err := os.ReadFile("/home/max/Desktop/chunkNotFull.bin")
@@ -189,7 +195,7 @@ func genHeightmap(chunk *save.Chunk) {
 		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
 	})
 	var set [16 * 16]bool
-	ws := level.NewBitStorage(9, 16*16, nil)
+	ws := level.NewBitStorage(bits.Len(uint(*dimHeight+1)), 16*16, nil)
 	for _, s := range chunk.Sections {
 		if len(s.BlockStates.Data) == 0 {
 			continue
@@ -206,7 +212,7 @@ func genHeightmap(chunk *save.Chunk) {
 				}
 				state := states.Get(y*16*16 + i)
 				if !isAirState(state) {
-					ws.Set(i, int(s.Y)*16+y+64)
+					ws.Set(i, int(s.Y)*16+y-*dimMinY)
 					set[i] = true
 				}
 			}
@@ -0,0 +1,127 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+	"strings"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/nfnt/resize"
+)
+
+const compositeMaxLayers = 8
+
+type compositeLayer struct {
+	renderer *render.ChunkRenderer
+	opacity  int // percent
+}
+
+// Composite variants are renderer names joined with + and drawn
+// in order, each can have opacity in percent after ~,
+// for example shadedterrain+shading~50+chestheat
+func isCompositeVariant(variant string) bool {
+	return strings.ContainsAny(variant, "+~")
+}
+
+func parseCompositeVariant(variant string) ([]compositeLayer, error) {
+	parts := strings.Split(variant, "+")
+	if len(parts) > compositeMaxLayers {
+		return nil, fmt.Errorf("more than %d layers in variant", compositeMaxLayers)
+	}
+	ret := []compositeLayer{}
+	for _, p := range parts {
+		name, opacity := p, 100
+		if i := strings.IndexByte(p, '~'); i >= 0 {
+			o, err := strconv.Atoi(p[i+1:])
+			if err != nil || o < 0 || o > 100 {
+				return nil, fmt.Errorf("bad opacity of layer %q", p)
+			}
+			name, opacity = p[:i], o
+		}
+		rr := renderers.Get(name)
+		if rr == nil {
+			return nil, fmt.Errorf("layer %q not found", name)
+		}
+		ret = append(ret, compositeLayer{renderer: rr, opacity: opacity})
+	}
+	return ret, nil
+}
+
+func compositeVariantString(layers []compositeLayer) string {
+	parts := []string{}
+	for _, l := range layers {
+		if l.opacity == 100 {
+			parts = append(parts, l.renderer.Name)
+		} else {
+			parts = append(parts, l.renderer.Name+"~"+strconv.Itoa(l.opacity))
+		}
+	}
+	return strings.Join(parts, "+")
+}
+
+// Layers are taken from image cache or rendered (and cached) separately,
+// only the result is cached under composite variant
+func renderCompositeTile(loc primitives.ImageLocation, ignoreCache bool) (*image.RGBA, error) {
+	layers, err := parseCompositeVariant(loc.Variant)
+	if err != nil {
+		return nil, err
+	}
+	imgs := make([]*image.RGBA, len(layers))
+	size := 0
+	for i, l := range layers {
+		if l.opacity == 0 {
+			continue
+		}
+		lloc := loc
+		lloc.Variant = l.renderer.Name
+		img, err := imageGetSync(normalizeImageLocation(lloc), ignoreCache)
+		if err != nil {
+			return nil, fmt.Errorf("layer %q: %w", l.renderer.Name, err)
+		}
+		imgs[i] = img
+		if img != nil && img.Bounds().Dx() > size {
+			size = img.Bounds().Dx()
+		}
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	// magnified layers drawn by RenderTile are bigger than ones cut out of chunks
+	ret := image.NewRGBA(image.Rect(0, 0, size, size))
+	for i, l := range layers {
+		if imgs[i] == nil {
+			continue
+		}
+		var img image.Image = imgs[i]
+		if imgs[i].Bounds().Dx() != size {
+			img = resize.Resize(uint(size), uint(size), imgs[i], resize.NearestNeighbor)
+		}
+		mask := &image.Uniform{color.Alpha{uint8(l.opacity * 255 / 100)}}
+		draw.DrawMask(ret, ret.Bounds(), img, img.Bounds().Min, mask, image.Point{}, draw.Over)
+	}
+	return ret, nil
+}
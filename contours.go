@@ -0,0 +1,104 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"strconv"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/render"
+)
+
+var (
+	contourColor      = color.NRGBA{60, 30, 0, 110}
+	contourIndexColor = color.NRGBA{60, 30, 0, 230}
+)
+
+// every that many lines is an index line
+const contourIndexEvery = 5
+
+// Iso-height lines every contours_interval blocks, lines are drawn on the
+// upper side of height change so they are one pixel wide and continuous
+// across chunk borders. Chunks are drawn only for storage level tiles,
+// far zoom tiles are averaged from them so lines fade out there.
+func drawChunkContours(d render.ChunkData) *image.RGBA {
+	t := time.Now()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	chunk := d.Get()
+	if chunk == nil {
+		return img
+	}
+	interval := cfg.GetDSInt(10, "contours_interval")
+	if interval < 1 {
+		interval = 1
+	}
+	heights := getAreaHeights(d, false)
+	height := heights.get
+	var indexed [16 * 16]bool
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			h, _ := height(x, z)
+			band := floorDiv(h, interval)
+			index := false
+			line := false
+			for _, o := range [4][2]int{{0, -1}, {1, 0}, {0, 1}, {-1, 0}} {
+				nh, ok := height(x+o[0], z+o[1])
+				if !ok || floorDiv(nh, interval) >= band {
+					continue
+				}
+				line = true
+				if floorDiv(nh, interval*contourIndexEvery) < floorDiv(h, interval*contourIndexEvery) {
+					index = true
+				}
+			}
+			indexed[z*16+x] = index
+			if index {
+				img.Set(x, z, contourIndexColor)
+			} else if line {
+				img.Set(x, z, contourColor)
+			}
+		}
+	}
+	if floorDiv(int(chunk.XPos), 4)*4 == int(chunk.XPos) && floorDiv(int(chunk.ZPos), 4)*4 == int(chunk.ZPos) {
+		drawContourLabel(img, heights[1][1], indexed[:], interval*contourIndexEvery)
+	}
+	appendMetrics(time.Since(t), "contours")
+	return img
+}
+
+// labels first index line pixel that has room for the label within the chunk
+func drawContourLabel(img *image.RGBA, hm []int, indexed []bool, step int) {
+	for z := 0; z+gridGlyphHeight+1 <= 16; z++ {
+		for x := 0; x < 16; x++ {
+			if !indexed[z*16+x] {
+				continue
+			}
+			text := strconv.Itoa(floorDiv(hm[z*16+x], step) * step)
+			if x+gridLabelWidth(text)+2 > 16 {
+				continue
+			}
+			drawGridLabel(img, x, z, gridLabelBack, text)
+			return
+		}
+	}
+}
@@ -0,0 +1,185 @@
+package biomes
+
+import "image/color"
+
+type GrassModifier int
+
+const (
+	GrassModifierNone GrassModifier = iota
+	GrassModifierDarkForest
+	GrassModifierSwamp
+)
+
+// Biome parameters that affect grass, foliage and water colors.
+// Colors with zero alpha are not overridden.
+type Climate struct {
+	Temperature   float64
+	Downfall      float64
+	Water         color.RGBA
+	Grass         color.RGBA
+	Foliage       color.RGBA
+	GrassModifier GrassModifier
+}
+
+var (
+	DefaultClimate = Climate{Temperature: 0.5, Downfall: 0.5}
+	DefaultWater   = color.RGBA{0x3F, 0x76, 0xE4, 0xFF}
+
+	swampGrass = color.RGBA{0x6A, 0x70, 0x39, 0xFF}
+
+	// Corners of vanilla colormap triangles: hot and wet, hot and dry, cold.
+	// Textures can't be shipped so colormap is interpolated between them.
+	grassColormap   = [3]color.RGBA{{0x47, 0xCD, 0x33, 0xFF}, {0xBF, 0xB7, 0x55, 0xFF}, {0x80, 0xB4, 0x97, 0xFF}}
+	foliageColormap = [3]color.RGBA{{0x1A, 0xBF, 0x00, 0xFF}, {0xAE, 0xA4, 0x2A, 0xFF}, {0x60, 0xA1, 0x7B, 0xFF}}
+)
+
+func rgb(c uint32) color.RGBA {
+	return color.RGBA{uint8(c >> 16), uint8(c >> 8), uint8(c), 0xFF}
+}
+
+var BiomeClimate = map[string]Climate{
+	"badlands":                 {Temperature: 2, Downfall: 0, Grass: rgb(0x90814D), Foliage: rgb(0x9E814D)},
+	"bamboo_jungle":            {Temperature: 0.95, Downfall: 0.9},
+	"basalt_deltas":            {Temperature: 2, Downfall: 0},
+	"beach":                    {Temperature: 0.8, Downfall: 0.4},
+	"birch_forest":             {Temperature: 0.6, Downfall: 0.6},
+	"cherry_grove":             {Temperature: 0.5, Downfall: 0.8, Water: rgb(0x5DB7EF), Grass: rgb(0xB6DB61), Foliage: rgb(0xB6DB61)},
+	"cold_ocean":               {Temperature: 0.5, Downfall: 0.5, Water: rgb(0x3D57D6)},
+	"crimson_forest":           {Temperature: 2, Downfall: 0},
+	"dark_forest":              {Temperature: 0.7, Downfall: 0.8, GrassModifier: GrassModifierDarkForest},
+	"deep_cold_ocean":          {Temperature: 0.5, Downfall: 0.5, Water: rgb(0x3D57D6)},
+	"deep_dark":                {Temperature: 0.8, Downfall: 0.4},
+	"deep_frozen_ocean":        {Temperature: 0.5, Downfall: 0.5, Water: rgb(0x3938C9)},
+	"deep_lukewarm_ocean":      {Temperature: 0.5, Downfall: 0.5, Water: rgb(0x45ADF2)},
+	"deep_ocean":               {Temperature: 0.5, Downfall: 0.5},
+	"desert":                   {Temperature: 2, Downfall: 0},
+	"dripstone_caves":          {Temperature: 0.8, Downfall: 0.4},
+	"end_barrens":              {Temperature: 0.5, Downfall: 0.5},
+	"end_highlands":            {Temperature: 0.5, Downfall: 0.5},
+	"end_midlands":             {Temperature: 0.5, Downfall: 0.5},
+	"eroded_badlands":          {Temperature: 2, Downfall: 0, Grass: rgb(0x90814D), Foliage: rgb(0x9E814D)},
+	"flower_forest":            {Temperature: 0.7, Downfall: 0.8},
+	"forest":                   {Temperature: 0.7, Downfall: 0.8},
+	"frozen_ocean":             {Temperature: 0, Downfall: 0.5, Water: rgb(0x3938C9)},
+	"frozen_peaks":             {Temperature: -0.7, Downfall: 0.9},
+	"frozen_river":             {Temperature: 0, Downfall: 0.5, Water: rgb(0x3938C9)},
+	"grove":                    {Temperature: -0.2, Downfall: 0.8},
+	"ice_spikes":               {Temperature: 0, Downfall: 0.5},
+	"jagged_peaks":             {Temperature: -0.7, Downfall: 0.9},
+	"jungle":                   {Temperature: 0.95, Downfall: 0.9},
+	"lukewarm_ocean":           {Temperature: 0.5, Downfall: 0.5, Water: rgb(0x45ADF2)},
+	"lush_caves":               {Temperature: 0.5, Downfall: 0.5},
+	"mangrove_swamp":           {Temperature: 0.8, Downfall: 0.9, Water: rgb(0x3A7A6A), Foliage: rgb(0x8DB127), GrassModifier: GrassModifierSwamp},
+	"meadow":                   {Temperature: 0.5, Downfall: 0.8, Water: rgb(0x0E4ECF)},
+	"mushroom_fields":          {Temperature: 0.9, Downfall: 1},
+	"nether_wastes":            {Temperature: 2, Downfall: 0},
+	"ocean":                    {Temperature: 0.5, Downfall: 0.5},
+	"old_growth_birch_forest":  {Temperature: 0.6, Downfall: 0.6},
+	"old_growth_pine_taiga":    {Temperature: 0.3, Downfall: 0.8},
+	"old_growth_spruce_taiga":  {Temperature: 0.25, Downfall: 0.8},
+	"plains":                   {Temperature: 0.8, Downfall: 0.4},
+	"river":                    {Temperature: 0.5, Downfall: 0.5},
+	"savanna":                  {Temperature: 2, Downfall: 0},
+	"savanna_plateau":          {Temperature: 2, Downfall: 0},
+	"small_end_islands":        {Temperature: 0.5, Downfall: 0.5},
+	"snowy_beach":              {Temperature: 0.05, Downfall: 0.3, Water: rgb(0x3D57D6)},
+	"snowy_plains":             {Temperature: 0, Downfall: 0.5},
+	"snowy_slopes":             {Temperature: -0.3, Downfall: 0.9},
+	"snowy_taiga":              {Temperature: -0.5, Downfall: 0.4, Water: rgb(0x3D57D6)},
+	"soul_sand_valley":         {Temperature: 2, Downfall: 0},
+	"sparse_jungle":            {Temperature: 0.95, Downfall: 0.8},
+	"stony_peaks":              {Temperature: 1, Downfall: 0.3},
+	"stony_shore":              {Temperature: 0.2, Downfall: 0.3},
+	"sunflower_plains":         {Temperature: 0.8, Downfall: 0.4},
+	"swamp":                    {Temperature: 0.8, Downfall: 0.9, Water: rgb(0x617B64), Foliage: rgb(0x6A7039), GrassModifier: GrassModifierSwamp},
+	"taiga":                    {Temperature: 0.25, Downfall: 0.8},
+	"the_end":                  {Temperature: 0.5, Downfall: 0.5},
+	"the_void":                 {Temperature: 0.5, Downfall: 0.5},
+	"warm_ocean":               {Temperature: 0.5, Downfall: 0.5, Water: rgb(0x43D5EE)},
+	"warped_forest":            {Temperature: 2, Downfall: 0},
+	"windswept_forest":         {Temperature: 0.2, Downfall: 0.3},
+	"windswept_gravelly_hills": {Temperature: 0.2, Downfall: 0.3},
+	"windswept_hills":          {Temperature: 0.2, Downfall: 0.3},
+	"windswept_savanna":        {Temperature: 2, Downfall: 0},
+	"wooded_badlands":          {Temperature: 2, Downfall: 0, Grass: rgb(0x90814D), Foliage: rgb(0x9E814D)},
+}
+
+var climateByID = map[int]Climate{}
+
+func init() {
+	for name, id := range BiomeID {
+		if c, ok := BiomeClimate[name]; ok {
+			climateByID[id] = c
+		}
+	}
+}
+
+// Climate of biome by id from BiomeID, unknown biomes get DefaultClimate
+func GetClimate(id int) Climate {
+	c, ok := climateByID[id]
+	if !ok {
+		return DefaultClimate
+	}
+	return c
+}
+
+func (c Climate) GrassColor() color.RGBA {
+	var ret color.RGBA
+	switch {
+	case c.GrassModifier == GrassModifierSwamp:
+		return swampGrass
+	case c.Grass.A != 0:
+		ret = c.Grass
+	default:
+		ret = colormap(grassColormap, c.Temperature, c.Downfall)
+	}
+	if c.GrassModifier == GrassModifierDarkForest {
+		ret = color.RGBA{
+			R: uint8((uint16(ret.R&0xFE) + 0x28) / 2),
+			G: uint8((uint16(ret.G&0xFE) + 0x34) / 2),
+			B: uint8((uint16(ret.B&0xFE) + 0x0A) / 2),
+			A: 0xFF,
+		}
+	}
+	return ret
+}
+
+func (c Climate) FoliageColor() color.RGBA {
+	if c.Foliage.A != 0 {
+		return c.Foliage
+	}
+	return colormap(foliageColormap, c.Temperature, c.Downfall)
+}
+
+func (c Climate) WaterColor() color.RGBA {
+	if c.Water.A != 0 {
+		return c.Water
+	}
+	return DefaultWater
+}
+
+// Vanilla looks colormap up at x = 1-temperature, y = 1-temperature*downfall,
+// only the lower left triangle of the texture is used.
+func colormap(corners [3]color.RGBA, temperature, downfall float64) color.RGBA {
+	t := clamp01(temperature)
+	d := clamp01(downfall) * t
+	x, y := 1-t, 1-d
+	w := [3]float64{1 - y, y - x, x}
+	var r, g, b float64
+	for i, c := range corners {
+		r += float64(c.R) * w[i]
+		g += float64(c.G) * w[i]
+		b += float64(c.B) * w[i]
+	}
+	return color.RGBA{uint8(r + 0.5), uint8(g + 0.5), uint8(b + 0.5), 0xFF}
+}
+
+func clamp01(v float64) float64 {
+	if v < 0 {
+		return 0
+	}
+	if v > 1 {
+		return 1
+	}
+	return v
+}
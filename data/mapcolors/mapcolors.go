@@ -0,0 +1,355 @@
+package mapcolors
+
+import (
+	"image/color"
+	"strings"
+)
+
+// Base colors of vanilla map items, None is not drawn
+type MapColor uint8
+
+const (
+	None MapColor = iota
+	Grass
+	Sand
+	Wool
+	Fire
+	Ice
+	Metal
+	Plant
+	Snow
+	Clay
+	Dirt
+	Stone
+	Water
+	Wood
+	Quartz
+	ColorOrange
+	ColorMagenta
+	ColorLightBlue
+	ColorYellow
+	ColorLightGreen
+	ColorPink
+	ColorGray
+	ColorLightGray
+	ColorCyan
+	ColorPurple
+	ColorBlue
+	ColorBrown
+	ColorGreen
+	ColorRed
+	ColorBlack
+	Gold
+	Diamond
+	Lapis
+	Emerald
+	Podzol
+	Nether
+	TerracottaWhite
+	TerracottaOrange
+	TerracottaMagenta
+	TerracottaLightBlue
+	TerracottaYellow
+	TerracottaLightGreen
+	TerracottaPink
+	TerracottaGray
+	TerracottaLightGray
+	TerracottaCyan
+	TerracottaPurple
+	TerracottaBlue
+	TerracottaBrown
+	TerracottaGreen
+	TerracottaRed
+	TerracottaBlack
+	CrimsonNylium
+	CrimsonStem
+	CrimsonHyphae
+	WarpedNylium
+	WarpedStem
+	WarpedHyphae
+	WarpedWartBlock
+	Deepslate
+	RawIron
+	GlowLichen
+)
+
+var Colors = [...]color.RGBA{
+	None:                 {0, 0, 0, 0},
+	Grass:                {127, 178, 56, 255},
+	Sand:                 {247, 233, 163, 255},
+	Wool:                 {199, 199, 199, 255},
+	Fire:                 {255, 0, 0, 255},
+	Ice:                  {160, 160, 255, 255},
+	Metal:                {167, 167, 167, 255},
+	Plant:                {0, 124, 0, 255},
+	Snow:                 {255, 255, 255, 255},
+	Clay:                 {164, 168, 184, 255},
+	Dirt:                 {151, 109, 77, 255},
+	Stone:                {112, 112, 112, 255},
+	Water:                {64, 64, 255, 255},
+	Wood:                 {143, 119, 72, 255},
+	Quartz:               {255, 252, 245, 255},
+	ColorOrange:          {216, 127, 51, 255},
+	ColorMagenta:         {178, 76, 216, 255},
+	ColorLightBlue:       {102, 153, 216, 255},
+	ColorYellow:          {229, 229, 51, 255},
+	ColorLightGreen:      {127, 204, 25, 255},
+	ColorPink:            {242, 127, 165, 255},
+	ColorGray:            {76, 76, 76, 255},
+	ColorLightGray:       {153, 153, 153, 255},
+	ColorCyan:            {76, 127, 153, 255},
+	ColorPurple:          {127, 63, 178, 255},
+	ColorBlue:            {51, 76, 178, 255},
+	ColorBrown:           {102, 76, 51, 255},
+	ColorGreen:           {102, 127, 51, 255},
+	ColorRed:             {153, 51, 51, 255},
+	ColorBlack:           {25, 25, 25, 255},
+	Gold:                 {250, 238, 77, 255},
+	Diamond:              {92, 219, 213, 255},
+	Lapis:                {74, 128, 255, 255},
+	Emerald:              {0, 217, 58, 255},
+	Podzol:               {129, 86, 49, 255},
+	Nether:               {112, 2, 0, 255},
+	TerracottaWhite:      {209, 177, 161, 255},
+	TerracottaOrange:     {159, 82, 36, 255},
+	TerracottaMagenta:    {149, 87, 108, 255},
+	TerracottaLightBlue:  {112, 108, 138, 255},
+	TerracottaYellow:     {186, 133, 36, 255},
+	TerracottaLightGreen: {103, 117, 53, 255},
+	TerracottaPink:       {160, 77, 78, 255},
+	TerracottaGray:       {57, 41, 35, 255},
+	TerracottaLightGray:  {135, 107, 98, 255},
+	TerracottaCyan:       {87, 92, 92, 255},
+	TerracottaPurple:     {122, 73, 88, 255},
+	TerracottaBlue:       {76, 62, 92, 255},
+	TerracottaBrown:      {76, 50, 35, 255},
+	TerracottaGreen:      {76, 82, 42, 255},
+	TerracottaRed:        {142, 60, 46, 255},
+	TerracottaBlack:      {37, 22, 16, 255},
+	CrimsonNylium:        {189, 48, 49, 255},
+	CrimsonStem:          {148, 63, 97, 255},
+	CrimsonHyphae:        {92, 25, 29, 255},
+	WarpedNylium:         {22, 126, 134, 255},
+	WarpedStem:           {58, 142, 140, 255},
+	WarpedHyphae:         {86, 44, 62, 255},
+	WarpedWartBlock:      {20, 180, 133, 255},
+	Deepslate:            {100, 100, 100, 255},
+	RawIron:              {216, 175, 147, 255},
+	GlowLichen:           {127, 167, 150, 255},
+}
+
+// Multipliers applied to base colors, maps pick one by
+// comparing block height with the one to the north
+type Brightness uint8
+
+const (
+	Low    Brightness = 180
+	Normal Brightness = 220
+	High   Brightness = 255
+	Lowest Brightness = 135
+)
+
+func (c MapColor) Shaded(b Brightness) color.RGBA {
+	if int(c) >= len(Colors) || c == None {
+		return color.RGBA{}
+	}
+	base := Colors[c]
+	return color.RGBA{
+		R: uint8(uint16(base.R) * uint16(b) / 255),
+		G: uint8(uint16(base.G) * uint16(b) / 255),
+		B: uint8(uint16(base.B) * uint16(b) / 255),
+		A: 255,
+	}
+}
+
+var dyeColors = map[string]MapColor{
+	"white":      Snow,
+	"orange":     ColorOrange,
+	"magenta":    ColorMagenta,
+	"light_blue": ColorLightBlue,
+	"yellow":     ColorYellow,
+	"lime":       ColorLightGreen,
+	"pink":       ColorPink,
+	"gray":       ColorGray,
+	"light_gray": ColorLightGray,
+	"cyan":       ColorCyan,
+	"purple":     ColorPurple,
+	"blue":       ColorBlue,
+	"brown":      ColorBrown,
+	"green":      ColorGreen,
+	"red":        ColorRed,
+	"black":      ColorBlack,
+}
+
+var terracottaColors = map[string]MapColor{
+	"white":      TerracottaWhite,
+	"orange":     TerracottaOrange,
+	"magenta":    TerracottaMagenta,
+	"light_blue": TerracottaLightBlue,
+	"yellow":     TerracottaYellow,
+	"lime":       TerracottaLightGreen,
+	"pink":       TerracottaPink,
+	"gray":       TerracottaGray,
+	"light_gray": TerracottaLightGray,
+	"cyan":       TerracottaCyan,
+	"purple":     TerracottaPurple,
+	"blue":       TerracottaBlue,
+	"brown":      TerracottaBrown,
+	"green":      TerracottaGreen,
+	"red":        TerracottaRed,
+	"black":      TerracottaBlack,
+}
+
+// Colors of planks and log tops, bark colors are in woodBarkColors
+var woodColors = map[string]MapColor{
+	"oak":      Wood,
+	"spruce":   Podzol,
+	"birch":    Sand,
+	"jungle":   Dirt,
+	"acacia":   ColorOrange,
+	"dark_oak": ColorBrown,
+	"mangrove": ColorRed,
+	"cherry":   TerracottaWhite,
+	"bamboo":   ColorYellow,
+	"crimson":  CrimsonStem,
+	"warped":   WarpedStem,
+}
+
+var woodBarkColors = map[string]MapColor{
+	"oak":      Podzol,
+	"spruce":   ColorBrown,
+	"birch":    Quartz,
+	"jungle":   Podzol,
+	"acacia":   Stone,
+	"dark_oak": ColorBrown,
+	"mangrove": Podzol,
+	"cherry":   TerracottaGray,
+	"crimson":  CrimsonHyphae,
+	"warped":   WarpedHyphae,
+}
+
+var dyedSuffixes = []string{
+	"_wool", "_carpet", "_concrete", "_concrete_powder", "_stained_glass", "_stained_glass_pane",
+	"_glazed_terracotta", "_bed", "_banner", "_wall_banner", "_shulker_box", "_candle",
+}
+
+var woodSuffixes = []string{
+	"_planks", "_stairs", "_slab", "_fence", "_fence_gate", "_door", "_trapdoor",
+	"_pressure_plate", "_sign", "_wall_sign", "_hanging_sign", "_wall_hanging_sign", "_log", "_stem",
+}
+
+var barkSuffixes = []string{"_wood", "_hyphae"}
+
+var blockColors = map[string]MapColor{
+	"air": None, "cave_air": None, "void_air": None, "barrier": None, "light": None, "structure_void": None,
+	"glass": None, "glass_pane": None, "tinted_glass": ColorGray,
+	"torch": None, "wall_torch": None, "soul_torch": None, "soul_wall_torch": None,
+	"redstone_torch": None, "redstone_wall_torch": None, "redstone_wire": None, "tripwire": None, "tripwire_hook": None,
+	"rail": None, "powered_rail": None, "detector_rail": None, "activator_rail": None, "lever": None,
+	"repeater": None, "comparator": None, "flower_pot": None, "ladder": None, "scaffolding": Sand,
+
+	"grass_block": Grass, "slime_block": Grass,
+	"dirt": Dirt, "coarse_dirt": Dirt, "rooted_dirt": Dirt, "farmland": Dirt, "dirt_path": Dirt, "granite": Dirt,
+	"polished_granite": Dirt, "jukebox": Dirt, "brown_mushroom_block": Dirt, "packed_mud": Dirt,
+	"podzol": Podzol, "mycelium": ColorPurple, "mud": TerracottaCyan, "mud_bricks": TerracottaLightGray,
+	"sand": Sand, "suspicious_sand": Sand, "sandstone": Sand, "cut_sandstone": Sand, "chiseled_sandstone": Sand,
+	"smooth_sandstone": Sand, "end_stone": Sand, "end_stone_bricks": Sand, "glowstone": Sand, "bone_block": Sand,
+	"red_sand": ColorOrange, "red_sandstone": ColorOrange, "cut_red_sandstone": ColorOrange,
+	"chiseled_red_sandstone": ColorOrange, "smooth_red_sandstone": ColorOrange, "pumpkin": ColorOrange,
+	"carved_pumpkin": ColorOrange, "jack_o_lantern": ColorOrange, "honey_block": ColorOrange, "honeycomb_block": ColorOrange,
+	"gravel": Stone, "suspicious_gravel": Stone, "stone": Stone, "cobblestone": Stone, "mossy_cobblestone": Stone,
+	"smooth_stone": Stone, "stone_bricks": Stone, "mossy_stone_bricks": Stone, "cracked_stone_bricks": Stone,
+	"chiseled_stone_bricks": Stone, "andesite": Stone, "polished_andesite": Stone, "bedrock": Stone,
+	"furnace": Stone, "dispenser": Stone, "dropper": Stone, "observer": Stone, "piston": Stone, "sticky_piston": Stone,
+	"spawner": Stone, "stonecutter": Stone, "cobweb": Wool, "mushroom_stem": Wool,
+	"diorite": Quartz, "polished_diorite": Quartz, "quartz_block": Quartz, "quartz_bricks": Quartz,
+	"quartz_pillar": Quartz, "chiseled_quartz_block": Quartz, "smooth_quartz": Quartz, "sea_lantern": Quartz,
+	"clay": Clay, "infested_stone": Clay,
+	"snow": Snow, "snow_block": Snow, "powder_snow": Snow,
+	"ice": Ice, "packed_ice": Ice, "blue_ice": Ice, "frosted_ice": Ice,
+	"water": Water, "bubble_column": Water, "lava": Fire, "fire": Fire, "tnt": Fire, "redstone_block": Fire,
+	"iron_block": Metal, "iron_door": Metal, "iron_trapdoor": Metal, "iron_bars": None, "anvil": Metal,
+	"chipped_anvil": Metal, "damaged_anvil": Metal, "cauldron": Stone, "water_cauldron": Stone, "hopper": Stone,
+	"gold_block": Gold, "raw_gold_block": Gold, "bell": Gold, "diamond_block": Diamond, "beacon": Diamond,
+	"emerald_block": Emerald, "lapis_block": Lapis, "coal_block": ColorBlack, "netherite_block": ColorBlack,
+	"obsidian": ColorBlack, "crying_obsidian": ColorBlack, "ancient_debris": ColorBlack, "blackstone": ColorBlack,
+	"polished_blackstone": ColorBlack, "polished_blackstone_bricks": ColorBlack, "basalt": ColorBlack,
+	"polished_basalt": ColorBlack, "smooth_basalt": ColorBlack, "dragon_egg": ColorBlack, "end_portal_frame": ColorGreen,
+	"raw_iron_block": RawIron, "raw_copper_block": ColorOrange,
+	"copper_block": ColorOrange, "cut_copper": ColorOrange, "exposed_copper": TerracottaLightGray,
+	"exposed_cut_copper": TerracottaLightGray, "weathered_copper": WarpedStem, "weathered_cut_copper": WarpedStem,
+	"oxidized_copper": WarpedNylium, "oxidized_cut_copper": WarpedNylium,
+	"amethyst_block": ColorPurple, "budding_amethyst": ColorPurple, "amethyst_cluster": ColorPurple,
+	"calcite": TerracottaWhite, "tuff": TerracottaGray, "dripstone_block": TerracottaBrown, "pointed_dripstone": TerracottaBrown,
+	"netherrack": Nether, "nether_bricks": Nether, "cracked_nether_bricks": Nether, "chiseled_nether_bricks": Nether,
+	"nether_quartz_ore": Nether, "nether_gold_ore": Nether, "magma_block": Nether,
+	"red_nether_bricks": Nether, "nether_wart_block": ColorRed, "shroomlight": ColorRed, "red_mushroom_block": ColorRed,
+	"bricks": ColorRed, "soul_sand": ColorBrown, "soul_soil": ColorBrown,
+	"crimson_nylium": CrimsonNylium, "warped_nylium": WarpedNylium, "warped_wart_block": WarpedWartBlock,
+	"prismarine": ColorCyan, "prismarine_bricks": Diamond, "dark_prismarine": Diamond,
+	"purpur_block": ColorMagenta, "purpur_pillar": ColorMagenta, "terracotta": ColorOrange,
+	"hay_block": ColorYellow, "sponge": ColorYellow, "wet_sponge": ColorYellow, "melon": ColorLightGreen,
+	"moss_block": ColorGreen, "moss_carpet": ColorGreen, "sculk": ColorBlack, "glow_lichen": GlowLichen,
+	"bookshelf": Wood, "chest": Wood, "trapped_chest": Wood, "crafting_table": Wood, "barrel": Wood,
+	"note_block": Wood, "lectern": Wood, "composter": Wood, "loom": Wood, "cartography_table": Wood,
+	"fletching_table": Wood, "smithing_table": Wood, "beehive": Wood, "bee_nest": ColorYellow,
+	"oak_leaves": Plant, "spruce_leaves": Plant, "birch_leaves": Plant, "jungle_leaves": Plant,
+	"acacia_leaves": Plant, "dark_oak_leaves": Plant, "mangrove_leaves": Plant, "azalea_leaves": Plant,
+	"flowering_azalea_leaves": Plant, "cherry_leaves": ColorPink,
+	"grass": Plant, "tall_grass": Plant, "fern": Plant, "large_fern": Plant, "dead_bush": Wood,
+	"sugar_cane": Plant, "cactus": Plant, "vine": Plant, "lily_pad": Plant, "kelp": Water, "kelp_plant": Water,
+	"seagrass": Water, "tall_seagrass": Water, "bamboo": Plant, "azalea": Plant, "flowering_azalea": Plant,
+	"wheat": Plant, "carrots": Plant, "potatoes": Plant, "beetroots": Plant, "sweet_berry_bush": Plant,
+	"dandelion": Plant, "poppy": Plant, "blue_orchid": Plant, "allium": Plant, "azure_bluet": Plant,
+	"oxeye_daisy": Plant, "cornflower": Plant, "lily_of_the_valley": Plant, "wither_rose": Plant,
+	"sunflower": Plant, "lilac": Plant, "rose_bush": Plant, "peony": Plant, "torchflower": Plant, "pink_petals": Plant,
+}
+
+var deepslatePrefixes = []string{"deepslate", "cobbled_deepslate", "polished_deepslate", "chiseled_deepslate", "reinforced_deepslate"}
+
+// Map color of block ID with or without minecraft namespace,
+// false if it is not known (and should be guessed from other colors)
+func ForBlock(id string) (MapColor, bool) {
+	id = strings.TrimPrefix(id, "minecraft:")
+	if c, ok := blockColors[id]; ok {
+		return c, true
+	}
+	if strings.HasSuffix(id, "_terracotta") && !strings.HasSuffix(id, "_glazed_terracotta") {
+		if c, ok := terracottaColors[strings.TrimSuffix(id, "_terracotta")]; ok {
+			return c, true
+		}
+	}
+	for _, s := range dyedSuffixes {
+		if c, ok := dyeColors[strings.TrimSuffix(id, s)]; ok && strings.HasSuffix(id, s) {
+			return c, true
+		}
+	}
+	for _, s := range barkSuffixes {
+		if c, ok := woodBarkColors[strings.TrimPrefix(strings.TrimSuffix(id, s), "stripped_")]; ok && strings.HasSuffix(id, s) {
+			if strings.HasPrefix(id, "stripped_") {
+				c = woodColors[strings.TrimPrefix(strings.TrimSuffix(id, s), "stripped_")]
+			}
+			return c, true
+		}
+	}
+	for _, s := range woodSuffixes {
+		if c, ok := woodColors[strings.TrimPrefix(strings.TrimSuffix(id, s), "stripped_")]; ok && strings.HasSuffix(id, s) {
+			return c, true
+		}
+	}
+	if strings.HasSuffix(id, "_button") || strings.HasPrefix(id, "potted_") || strings.HasSuffix(id, "_wall_torch") || strings.HasSuffix(id, "_torch") {
+		return None, true
+	}
+	if strings.HasSuffix(id, "_sapling") || strings.HasSuffix(id, "_tulip") {
+		return Plant, true
+	}
+	for _, p := range deepslatePrefixes {
+		if strings.HasPrefix(id, p) {
+			return Deepslate, true
+		}
+	}
+	if strings.HasSuffix(id, "_ore") {
+		return Stone, true
+	}
+	return None, false
+}
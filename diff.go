@@ -0,0 +1,205 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"errors"
+	"image"
+	"image/color"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gorilla/mux"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+type blockChange int
+
+const (
+	blockUnchanged blockChange = iota
+	blockPlaced
+	blockRemoved
+	blockReplaced
+)
+
+var blockChangeColors = map[blockChange]color.NRGBA{
+	blockPlaced:   {0, 255, 0, 200},
+	blockRemoved:  {255, 0, 0, 200},
+	blockReplaced: {255, 255, 0, 200},
+}
+
+// block properties (door opened, redstone powered) are not a change
+func getBlockChange(prev, cur block.StateID) blockChange {
+	prevAir, curAir := isAirState(prev), isAirState(cur)
+	switch {
+	case prevAir && curAir:
+		return blockUnchanged
+	case prevAir:
+		return blockPlaced
+	case curAir:
+		return blockRemoved
+	case block.StateList[prev].ID() != block.StateList[cur].ID():
+		return blockReplaced
+	default:
+		return blockUnchanged
+	}
+}
+
+type chunkDiff struct {
+	Placed   int
+	Removed  int
+	Replaced int
+	// topmost change of each column, indexed z*16+x
+	top [16 * 16]blockChange
+}
+
+func (d *chunkDiff) changed() bool {
+	return d.Placed+d.Removed+d.Replaced > 0
+}
+
+func diffChunks(prev, cur *save.Chunk) *chunkDiff {
+	ret := &chunkDiff{}
+	pb, cb := newChunkBlocks(prev), newChunkBlocks(cur)
+	var minY, maxY int
+	switch {
+	case len(pb.sections) == 0 && len(cb.sections) == 0:
+		return ret
+	case len(pb.sections) == 0:
+		minY, maxY = cb.minY, cb.maxY
+	case len(cb.sections) == 0:
+		minY, maxY = pb.minY, pb.maxY
+	default:
+		minY, maxY = pb.minY, pb.maxY
+		if cb.minY < minY {
+			minY = cb.minY
+		}
+		if cb.maxY > maxY {
+			maxY = cb.maxY
+		}
+	}
+	for y := maxY - 1; y >= minY; y-- {
+		_, pok := pb.sections[floorDiv(y, 16)]
+		_, cok := cb.sections[floorDiv(y, 16)]
+		if !pok && !cok {
+			continue
+		}
+		for i := 0; i < 16*16; i++ {
+			x, z := i%16, i/16
+			c := getBlockChange(pb.get(x, y, z), cb.get(x, y, z))
+			switch c {
+			case blockUnchanged:
+				continue
+			case blockPlaced:
+				ret.Placed++
+			case blockRemoved:
+				ret.Removed++
+			case blockReplaced:
+				ret.Replaced++
+			}
+			if ret.top[i] == blockUnchanged {
+				ret.top[i] = c
+			}
+		}
+	}
+	return ret
+}
+
+// Columns are colored by their topmost change: green for placed blocks,
+// red for removed and yellow for replaced ones. Chunks that were not
+// stored at Options.Since are left empty.
+func drawChunkDiff(d render.ChunkData) *image.RGBA {
+	t := time.Now()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	prev, cur := d.GetPrevious(), d.Get()
+	if prev == nil || cur == nil {
+		return img
+	}
+	diff := diffChunks(prev, cur)
+	for i, c := range diff.top {
+		if c != blockUnchanged {
+			img.Set(i%16, i/16, blockChangeColors[c])
+		}
+	}
+	appendMetrics(time.Since(t), "diff")
+	return img
+}
+
+// largest side of area diff summary can be requested for, in chunks
+const diffSummaryMaxSize = 128
+
+func apiChunkDiffSummary(w http.ResponseWriter, r *http.Request) (int, string) {
+	params := mux.Vars(r)
+	wname := params["world"]
+	dname := params["dim"]
+	since, err := parseSince(r.URL.Query().Get("since"))
+	if err != nil || since == 0 {
+		return 400, "Bad or missing since"
+	}
+	var area [4]int
+	for i, k := range []string{"x0", "z0", "x1", "z1"} {
+		v, err := strconv.Atoi(r.URL.Query().Get(k))
+		if err != nil {
+			return 400, "Bad " + k
+		}
+		area[i] = v
+	}
+	if area[2] <= area[0] || area[3] <= area[1] || area[2]-area[0] > diffSummaryMaxSize || area[3]-area[1] > diffSummaryMaxSize {
+		return 400, "Area must be non-empty and at most " + strconv.Itoa(diffSummaryMaxSize) + " chunks wide"
+	}
+	_, s, err := chunkStorage.GetWorldStorage(storages, wname)
+	if err != nil {
+		return 500, "Error getting world storage: " + err.Error()
+	}
+	if s == nil {
+		return 404, "World does not exist"
+	}
+	if !s.GetAbilities().CanPreserveOldChunks {
+		return 400, "Storage of the world does not keep chunk history"
+	}
+	cc, err := render.GetRegionData(s, wname, dname, render.DataNeeds{History: true}, render.Options{Since: time.Unix(since, 0)}, area[0], area[1], area[2], area[3])
+	if err != nil {
+		if errors.Is(err, chunkStorage.ErrNotImplemented) {
+			return 400, "Storage of the world does not keep chunk history"
+		}
+		return 500, "Error getting chunk data: " + err.Error()
+	}
+	type changedChunk struct {
+		X, Z int
+		*chunkDiff
+	}
+	ret := []changedChunk{}
+	for _, c := range cc {
+		prev, cur := c.Data.GetPrevious(), c.Data.Get()
+		if prev == nil || cur == nil {
+			continue
+		}
+		diff := diffChunks(prev, cur)
+		if diff.changed() {
+			ret = append(ret, changedChunk{X: c.X, Z: c.Z, chunkDiff: diff})
+		}
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, ret)
+}
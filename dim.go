@@ -57,12 +57,7 @@ func dimensionHandler(w http.ResponseWriter, r *http.Request) {
 		plainmsg(w, r, plainmsgColorRed, "Dimension not found")
 		return
 	}
-	layers := make([]ttype, 0, len(ttypes))
-	for t := range ttypes {
-		layers = append(layers, t)
-	}
-	sort.Slice(layers, func(i, j int) bool { return strings.Compare(layers[i].Name, layers[j].Name) > 0 })
-	templateRespond("dim", w, r, map[string]interface{}{"Dim": dim, "World": world, "Layers": layers})
+	templateRespond("dim", w, r, map[string]interface{}{"Dim": dim, "World": world, "Layers": renderers.List()})
 }
 
 func apiAddDimension(w http.ResponseWriter, r *http.Request) (int, string) {
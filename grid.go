@@ -0,0 +1,199 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"strconv"
+)
+
+var (
+	gridChunkColor  = color.NRGBA{0, 0, 0, 80}
+	gridRegionColor = color.NRGBA{255, 255, 255, 200}
+	gridLabelColor  = color.NRGBA{255, 255, 255, 255}
+	gridLabelBack   = color.NRGBA{0, 0, 0, 140}
+	gridChunkBack   = color.NRGBA{0, 0, 0, 60}
+)
+
+// 3x5 pixel glyphs, each row is 3 bits from left to right
+var gridGlyphs = map[rune][5]uint8{
+	'0': {7, 5, 5, 5, 7},
+	'1': {2, 6, 2, 2, 7},
+	'2': {7, 1, 7, 4, 7},
+	'3': {7, 1, 3, 1, 7},
+	'4': {5, 5, 7, 1, 1},
+	'5': {7, 4, 7, 1, 7},
+	'6': {7, 4, 7, 5, 7},
+	'7': {7, 1, 1, 2, 2},
+	'8': {7, 5, 7, 5, 7},
+	'9': {7, 5, 7, 1, 7},
+	'-': {0, 0, 7, 0, 0},
+	'.': {0, 0, 0, 0, 2},
+	'r': {0, 6, 5, 4, 4},
+}
+
+const (
+	gridGlyphWidth  = 4 // with spacing
+	gridGlyphHeight = 6
+)
+
+func gridLabelWidth(text string) int {
+	return len(text)*gridGlyphWidth - 1
+}
+
+// label on top of back color, top left corner at x, y
+func drawGridLabel(img *image.RGBA, x, y int, back color.NRGBA, lines ...string) {
+	w := 0
+	for _, l := range lines {
+		if gridLabelWidth(l) > w {
+			w = gridLabelWidth(l)
+		}
+	}
+	r := image.Rect(x, y, x+w+2, y+len(lines)*gridGlyphHeight+1)
+	draw.Draw(img, r, &image.Uniform{back}, image.Point{}, draw.Over)
+	for li, l := range lines {
+		for ci, c := range l {
+			g := gridGlyphs[c]
+			for gy := 0; gy < 5; gy++ {
+				for gx := 0; gx < 3; gx++ {
+					if g[gy]&(4>>gx) != 0 {
+						img.Set(x+1+ci*gridGlyphWidth+gx, y+1+li*gridGlyphHeight+gy, gridLabelColor)
+					}
+				}
+			}
+		}
+	}
+}
+
+// smallest power of two multiple of step that is at least min pixels wide
+func gridStep(step int, ppc float64, min float64) int {
+	for float64(step)*ppc < min {
+		step *= 2
+	}
+	return step
+}
+
+// Draws chunk and region borders with coordinates for tile of scale s at x z,
+// each tile only draws it's top and left borders so lines are not doubled.
+// Chunk lines and labels are dropped or thinned out as tiles cover more chunks.
+func drawGridTile(s, x, z, size int) *image.RGBA {
+	if s < 0 {
+		return drawGridMagnifiedTile(-s, x, z, size)
+	}
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	n := 1 << s // chunks per tile side
+	cx0, cz0 := x*n, z*n
+	ppc := float64(size) / float64(n)
+	chunkStep := gridStep(1, ppc, 4)
+	regionStep := gridStep(32, ppc, 8)
+	line := func(c int, vertical bool, col color.NRGBA) {
+		p := int(float64(c) * ppc)
+		r := image.Rect(0, p, size, p+1)
+		if vertical {
+			r = image.Rect(p, 0, p+1, size)
+		}
+		draw.Draw(img, r, &image.Uniform{col}, image.Point{}, draw.Over)
+	}
+	for i := 0; i < n; i++ {
+		for _, vertical := range []bool{true, false} {
+			c := cz0 + i
+			if vertical {
+				c = cx0 + i
+			}
+			switch {
+			case c%regionStep == 0:
+				line(i, vertical, gridRegionColor)
+			case chunkStep < 32 && c%chunkStep == 0:
+				line(i, vertical, gridChunkColor)
+			}
+		}
+	}
+	if ppc >= 16 {
+		for i := 0; i < n*n; i++ {
+			cx, cz := cx0+i%n, cz0+i/n
+			lx, lz := strconv.Itoa(cx), strconv.Itoa(cz)
+			if gridLabelWidth(lx)+2 > int(ppc) || gridLabelWidth(lz)+2 > int(ppc) {
+				continue
+			}
+			drawGridLabel(img, int(float64(i%n)*ppc)+1, int(float64(i/n)*ppc)+1, gridChunkBack, lx, lz)
+		}
+	}
+	// same spacing for the whole tile, so it is taken from the widest label
+	labelWidth := 0
+	for _, rx := range []int{floorDiv(cx0, 32), floorDiv(cx0+n-1, 32)} {
+		for _, rz := range []int{floorDiv(cz0, 32), floorDiv(cz0+n-1, 32)} {
+			if w := gridLabelWidth(fmt.Sprintf("r.%d.%d", rx, rz)); w > labelWidth {
+				labelWidth = w
+			}
+		}
+	}
+	labelStep := gridStep(1, 32*ppc, float64(labelWidth+8))
+	for rx := floorDiv(cx0+31, 32); rx*32 < cx0+n; rx++ {
+		for rz := floorDiv(cz0+31, 32); rz*32 < cz0+n; rz++ {
+			if rx%labelStep != 0 || rz%labelStep != 0 {
+				continue
+			}
+			drawGridLabel(img, int(float64(rx*32-cx0)*ppc)+2, int(float64(rz*32-cz0)*ppc)+2, gridLabelBack, fmt.Sprintf("r.%d.%d", rx, rz))
+		}
+	}
+	return img
+}
+
+// Magnified tile covers part of one chunk, borders and labels are drawn
+// only by tiles at the chunk's top left corner.
+func drawGridMagnifiedTile(k, x, z, size int) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, size, size))
+	m := 1 << k
+	cx, cz := x>>k, z>>k
+	left, top := x&(m-1) == 0, z&(m-1) == 0
+	if left {
+		col := gridChunkColor
+		if cx%32 == 0 {
+			col = gridRegionColor
+		}
+		draw.Draw(img, image.Rect(0, 0, 1, size), &image.Uniform{col}, image.Point{}, draw.Over)
+	}
+	if top {
+		col := gridChunkColor
+		if cz%32 == 0 {
+			col = gridRegionColor
+		}
+		draw.Draw(img, image.Rect(0, 0, size, 1), &image.Uniform{col}, image.Point{}, draw.Over)
+	}
+	if !left || !top {
+		return img
+	}
+	lx, lz := strconv.Itoa(cx), strconv.Itoa(cz)
+	if gridLabelWidth(lx)+2 <= size && gridLabelWidth(lz)+2 <= size {
+		drawGridLabel(img, 2, 2, gridChunkBack, lx, lz)
+	}
+	if cx%32 == 0 && cz%32 == 0 {
+		text := fmt.Sprintf("r.%d.%d", cx/32, cz/32)
+		y := 2 + 2*gridGlyphHeight + 3
+		if gridLabelWidth(text)+2 <= size && y+gridGlyphHeight+1 <= size {
+			drawGridLabel(img, 2, y, gridLabelBack, text)
+		}
+	}
+	return img
+}
@@ -0,0 +1,102 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"image/draw"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/WebChunk/render"
+)
+
+// Blue for cold through yellow to red for hot, k is clamped to [0, 1]
+func heatColor(k float64) color.NRGBA {
+	if k < 0 {
+		k = 0
+	}
+	if k > 1 {
+		k = 1
+	}
+	if k < 0.5 {
+		k *= 2
+		return color.NRGBA{uint8(255 * k), uint8(255 * k), uint8(255 * (1 - k)), 160}
+	}
+	k = (k - 0.5) * 2
+	return color.NRGBA{255, uint8(255 * (1 - k)), 0, 160}
+}
+
+func drawHeatColor(k float64) *image.RGBA {
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	draw.Draw(img, img.Bounds(), &image.Uniform{heatColor(k)}, image.Point{}, draw.Src)
+	return img
+}
+
+// cached chunk age tiles are drawn again after this part of their window
+// passes so colors don't drift far from actual age
+const ageTileExpiryParts = 32
+
+func defaultAgeHours() int {
+	h := cfg.GetDSInt(7*24, "age_heatmap_hours")
+	if h <= 0 {
+		h = 1
+	}
+	return h
+}
+
+// Chunks stored just now are red, ones older than window of
+// Options.AgeHours are blue.
+func drawChunkAgeHeat(d render.ChunkData) *image.RGBA {
+	t := d.GetModDate()
+	if t == nil {
+		return image.NewRGBA(image.Rect(0, 0, 16, 16))
+	}
+	hours := d.GetOptions().AgeHours
+	if hours <= 0 {
+		hours = defaultAgeHours()
+	}
+	window := time.Duration(hours) * time.Hour
+	return drawHeatColor(1 - float64(time.Since(*t))/float64(window))
+}
+
+// tiles of age variants are outdated by time alone
+func ageTileExpired(loc primitives.ImageLocation, modTime time.Time) bool {
+	if loc.AgeHours <= 0 {
+		return false
+	}
+	return time.Since(modTime) > time.Duration(loc.AgeHours)*time.Hour/ageTileExpiryParts
+}
+
+// InhabitedTime is in ticks (20 per second), chunks players spent
+// configured number of hours or more in are red
+func drawChunkInhabitedHeat(d render.ChunkData) *image.RGBA {
+	c := d.Get()
+	if c == nil || c.InhabitedTime <= 0 {
+		return image.NewRGBA(image.Rect(0, 0, 16, 16))
+	}
+	window := cfg.GetDSInt(50, "inhabited_heatmap_hours")
+	if window <= 0 {
+		window = 1
+	}
+	return drawHeatColor(float64(c.InhabitedTime) / float64(window*60*60*20))
+}
@@ -2,20 +2,29 @@ package main
 
 import (
 	"log"
-	"sort"
+	"math/bits"
 
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
 	"github.com/maxsupermanhd/go-vmc/v764/save"
 )
 
-func genHeightmap(chunk *save.Chunk) []int {
+// only blocks at or below column tops are considered, nil tops mean no limit
+func genHeightmap(chunk *save.Chunk, tops []int) []int {
+	height, _ := genHeightmapColumns(chunk, tops)
+	return height
+}
+
+// same as genHeightmap but also tells which columns have any blocks,
+// height of columns without blocks is 0
+func genHeightmapColumns(chunk *save.Chunk, tops []int) ([]int, []bool) {
+	top := columnTopsMax(tops)
 	// TODO: this is a crutch, should be using MOTION_BLOCKING or WORLD_SURFACE heightmap from server if available
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
+	render.SortSections(chunk)
 	var height [16 * 16]int
 	var set [16 * 16]bool
 	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		if len(s.BlockStates.Palette) == 0 || int(s.Y)*16 > top {
 			continue
 		}
 		states := prepareSectionBlockIDs(&s)
@@ -23,9 +32,15 @@ func genHeightmap(chunk *save.Chunk) []int {
 			log.Printf("Chunk %d:%d section %d has broken pallete", chunk.XPos, chunk.YPos, s.Y)
 			continue
 		}
+		if len(s.BlockStates.Data) == 0 && isAirState(states.Get(0)) {
+			continue
+		}
 		for y := 15; y >= 0; y-- {
+			if int(s.Y)*16+y > top {
+				continue
+			}
 			for i := 16*16 - 1; i >= 0; i-- {
-				if set[i] {
+				if set[i] || aboveColumnTop(tops, i, int(s.Y)*16+y) {
 					continue
 				}
 				state := states.Get(y*16*16 + i)
@@ -36,5 +51,52 @@ func genHeightmap(chunk *save.Chunk) []int {
 			}
 		}
 	}
-	return height[:]
+	return height[:], set[:]
+}
+
+// Column heights from WORLD_SURFACE heightmap sent by server, falls back
+// to generating it when there is Y cutoff or ceiling to skip
+func surfaceHeightmap(chunk *save.Chunk, dt *save.DimensionType, opts render.Options) []int {
+	tops := chunkColumnTops(chunk, dt, opts)
+	if tops == nil && dt != nil && dt.Height > 0 {
+		if hm := chunk.Heightmaps["WORLD_SURFACE"]; len(hm) > 0 {
+			bpe := bits.Len(uint(dt.Height + 1))
+			if len(hm) == (16*16+64/bpe-1)/(64/bpe) {
+				bs := level.NewBitStorage(bpe, 16*16, hm)
+				ret := make([]int, 16*16)
+				for i := range ret {
+					// stored as height of the first air block above the surface
+					ret[i] = bs.Get(i) + int(dt.MinY) - 1
+				}
+				return ret
+			}
+		}
+	}
+	return genHeightmap(chunk, tops)
+}
+
+// Surface heights of chunk and ones around it, indexed by z then x offset
+// plus one, nil where chunk is not present or was not requested
+type areaHeights [3][3][]int
+
+func getAreaHeights(d render.ChunkData, corners bool) *areaHeights {
+	var ret areaHeights
+	dim, opts := d.GetDimension(), d.GetOptions()
+	around := []*save.Chunk{d.GetWestNorth(), d.GetNorth(), d.GetNorthEast(), d.GetWest(), d.Get(), d.GetEast(), d.GetSouthWest(), d.GetSouth(), d.GetEastSouth()}
+	for i, c := range around {
+		if c == nil || (!corners && i%2 == 0 && i != 4) {
+			continue
+		}
+		ret[i/3][i%3] = surfaceHeightmap(c, dim, opts)
+	}
+	return &ret
+}
+
+// x and z are relative to the center chunk and may reach one chunk outside it
+func (a *areaHeights) get(x, z int) (int, bool) {
+	hm := a[floorDiv(z, 16)+1][floorDiv(x, 16)+1]
+	if hm == nil {
+		return 0, false
+	}
+	return hm[(z&15)*16+(x&15)], true
 }
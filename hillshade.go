@@ -0,0 +1,116 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/WebChunk/render"
+)
+
+func defaultHillshade() primitives.Hillshade {
+	return primitives.Hillshade{
+		Azimuth:      cfg.GetDSInt(315, "hillshade_azimuth"),
+		Altitude:     cfg.GetDSInt(45, "hillshade_altitude"),
+		Exaggeration: cfg.GetDSFloat64(1, "hillshade_exaggeration"),
+	}
+}
+
+// Fills unset parameters from config and clamps the rest so equal
+// looking tiles share cache entries. Azimuth 0 is a valid direction
+// so only altitude and exaggeration are considered unset when zero.
+func normalizeHillshade(h primitives.Hillshade) primitives.Hillshade {
+	def := defaultHillshade()
+	if h == (primitives.Hillshade{}) {
+		h = def
+	}
+	if h.Altitude <= 0 {
+		h.Altitude = def.Altitude
+	}
+	if h.Exaggeration <= 0 {
+		h.Exaggeration = def.Exaggeration
+	}
+	h.Azimuth = (h.Azimuth%360 + 360) % 360
+	if h.Altitude < 1 {
+		h.Altitude = 1
+	}
+	if h.Altitude > 90 {
+		h.Altitude = 90
+	}
+	h.Exaggeration = math.Round(h.Exaggeration*10) / 10
+	if h.Exaggeration < 0.1 {
+		h.Exaggeration = 0.1
+	}
+	if h.Exaggeration > 10 {
+		h.Exaggeration = 10
+	}
+	return h
+}
+
+// Hillshade overlay, slope of each column is taken from all eight columns
+// around it (Horn's method) so there are no seams on chunk borders and
+// corners. Columns facing away from the light are darkened and ones
+// facing it are lightened compared to flat ground.
+func drawChunkShading(chunkContext render.ChunkData) (img *image.RGBA) {
+	t := time.Now()
+	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
+	if chunkContext.Get() == nil {
+		return
+	}
+	hs := normalizeHillshade(chunkContext.GetOptions().Hillshade)
+	az := float64(hs.Azimuth) * math.Pi / 180
+	alt := float64(hs.Altitude) * math.Pi / 180
+	// east, north, up
+	lx, ly, lz := math.Sin(az)*math.Cos(alt), math.Cos(az)*math.Cos(alt), math.Sin(alt)
+	heights := getAreaHeights(chunkContext, true)
+	for z := 0; z < 16; z++ {
+		for x := 0; x < 16; x++ {
+			h, _ := heights.get(x, z)
+			at := func(dx, dz int) float64 {
+				v, ok := heights.get(x+dx, z+dz)
+				if !ok {
+					v = h
+				}
+				return float64(v)
+			}
+			// z grows to the south
+			gx := (at(1, -1) + 2*at(1, 0) + at(1, 1) - at(-1, -1) - 2*at(-1, 0) - at(-1, 1)) / 8 * hs.Exaggeration
+			gz := (at(-1, 1) + 2*at(0, 1) + at(1, 1) - at(-1, -1) - 2*at(0, -1) - at(1, -1)) / 8 * hs.Exaggeration
+			nx, ny, nz := -gx, gz, 1.0
+			l := math.Sqrt(nx*nx + ny*ny + nz*nz)
+			lum := (nx*lx + ny*ly + nz*lz) / l
+			if lum < 0 {
+				lum = 0
+			}
+			if lum < lz {
+				img.Set(x, z, color.NRGBA{0, 0, 0, uint8(128 * (lz - lum) / lz)})
+			} else if lum > lz && lz < 1 {
+				img.Set(x, z, color.NRGBA{255, 255, 255, uint8(48 * (lum - lz) / (1 - lz))})
+			}
+		}
+	}
+	appendMetrics(time.Since(t), "shading")
+	return img
+}
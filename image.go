@@ -5,43 +5,156 @@ import (
 	"image/draw"
 	"log"
 	"runtime/debug"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
 	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/WebChunk/render"
 	"github.com/nfnt/resize"
 )
 
+type tileFlightKey struct {
+	loc         primitives.ImageLocation
+	ignoreCache bool
+}
+
+type tileFlight struct {
+	done chan struct{}
+	img  *image.RGBA
+	err  error
+}
+
+var (
+	tileFlightsLock sync.Mutex
+	tileFlights     = map[tileFlightKey]*tileFlight{}
+)
+
+// Concurrent gets of the same tile wait for the first one instead of
+// rendering it again, returned image is shared and must not be modified.
 func imageGetSync(loc primitives.ImageLocation, ignoreCache bool) (*image.RGBA, error) {
-	if !ignoreCache {
-		i := imageCacheGetBlockingLoc(loc)
+	k := tileFlightKey{loc: loc, ignoreCache: ignoreCache}
+	tileFlightsLock.Lock()
+	if f, ok := tileFlights[k]; ok {
+		tileFlightsLock.Unlock()
+		<-f.done
+		return f.img, f.err
+	}
+	f := &tileFlight{done: make(chan struct{})}
+	tileFlights[k] = f
+	tileFlightsLock.Unlock()
+	defer func() {
+		tileFlightsLock.Lock()
+		delete(tileFlights, k)
+		tileFlightsLock.Unlock()
+		close(f.done)
+	}()
+	f.img, f.err = imageGet(loc, ignoreCache)
+	return f.img, f.err
+}
+
+func imageGet(loc primitives.ImageLocation, ignoreCache bool) (*image.RGBA, error) {
+	// magnified composites are put together from layers so each gets it's block detail,
+	// magnified tiles drawn by RenderTile are not cut out of cached ones either
+	if !ignoreCache && !(loc.S < 0 && (isCompositeVariant(loc.Variant) || drawsWholeTile(loc.Variant))) {
+		i, stale := imageCacheGetFreshLoc(loc)
+		if stale && loc.S < imagecache.StorageLevel {
+			// smaller tiles are cut out of storage level one, it is drawn again whole
+			if _, err := imageGetSync(imagecache.StorageLevelLocation(loc), false); err == nil {
+				i, _ = imageCacheGetFreshLoc(loc)
+			}
+		}
 		if i != nil {
+			drawBlockDetail(i, loc)
 			return i, nil
 		}
 	}
-	img, err := renderTile(loc)
+	var img *image.RGBA
+	var err error
+	drawnAt := time.Now()
+	if isCompositeVariant(loc.Variant) {
+		img, err = renderCompositeTile(loc, ignoreCache)
+	} else {
+		img, err = renderTile(loc, ignoreCache)
+	}
 	if err != nil {
 		return img, err
 	}
-	if img != nil {
-		imageCacheSaveLoc(img, loc)
+	// magnified tiles are not stored, cache cuts them out of chunk images
+	if img != nil && loc.S >= 0 {
+		imageCacheSaveLoc(img, loc, drawnAt)
 	}
+	drawBlockDetail(img, loc)
 	return img, err
 }
 
-func renderTile(loc primitives.ImageLocation) (*image.RGBA, error) {
+// drops parameters renderer does not use so they don't split the cache
+// composite variants keep parameters any of their layers use
+func normalizeImageLocation(loc primitives.ImageLocation) primitives.ImageLocation {
+	var supportsY, history, needsQuery, hillshade, age bool
+	if layers, err := parseCompositeVariant(loc.Variant); err == nil {
+		loc.Variant = compositeVariantString(layers)
+		for _, l := range layers {
+			supportsY = supportsY || l.renderer.SupportsY
+			history = history || l.renderer.History
+			needsQuery = needsQuery || l.renderer.NeedsQuery
+			hillshade = hillshade || l.renderer.SupportsHillshade
+			age = age || l.renderer.SupportsAge
+		}
+	}
+	if !supportsY {
+		loc.Y = 0
+		loc.HasY = false
+	}
+	if !history {
+		loc.Since = 0
+	}
+	if !needsQuery {
+		loc.Query = ""
+	}
+	if hillshade {
+		loc.Hillshade = normalizeHillshade(loc.Hillshade)
+	} else {
+		loc.Hillshade = primitives.Hillshade{}
+	}
+	if !age {
+		loc.AgeHours = 0
+	} else if loc.AgeHours <= 0 {
+		loc.AgeHours = defaultAgeHours()
+	}
+	return loc
+}
+
+func renderOptionsFromLocation(loc primitives.ImageLocation) render.Options {
+	opts := render.Options{
+		Y:         loc.Y,
+		HasY:      loc.HasY,
+		Query:     loc.Query,
+		Hillshade: loc.Hillshade,
+		AgeHours:  loc.AgeHours,
+	}
+	if loc.Since != 0 {
+		opts.Since = time.Unix(loc.Since, 0)
+	}
+	return opts
+}
+
+func renderTile(loc primitives.ImageLocation, ignoreCache bool) (*image.RGBA, error) {
 
-	f := findTTypeProviderFunc(loc)
-	if f == nil {
+	rr := renderers.Get(loc.Variant)
+	if rr == nil {
 		log.Printf("Image variant %q was not found", loc.Variant)
 		return nil, nil
 	}
-	ff := *f
 
-	_, s, err := chunkStorage.GetWorldStorage(storages, loc.World)
-	if err != nil {
-		return nil, nil
+	if loc.S < 0 {
+		if rr.RenderTile != nil {
+			return rr.RenderTile(loc.S, loc.X, loc.Z, magnifiedRenderTileSize), nil
+		}
+		return renderMagnifiedTile(loc, ignoreCache)
 	}
-	getter, painter := ff(s)
 
 	scale := 1
 	if loc.S > 0 {
@@ -53,51 +166,133 @@ func renderTile(loc primitives.ImageLocation) (*image.RGBA, error) {
 		imagesize = 512
 	}
 
+	if rr.RenderTile != nil {
+		return rr.RenderTile(loc.S, loc.X, loc.Z, imagesize), nil
+	}
+
+	if loc.S > imagecache.StorageLevel {
+		return renderDownscaledTile(loc, ignoreCache)
+	}
+
+	_, s, err := chunkStorage.GetWorldStorage(storages, loc.World)
+	if err != nil {
+		return nil, nil
+	}
+
 	img := image.NewRGBA(image.Rect(0, 0, int(imagesize), int(imagesize)))
 	imagescale := int(imagesize / scale)
 	offsetx := loc.X * scale
 	offsety := loc.Z * scale
-	cc, err := getter(loc.World, loc.Dimension, loc.X*scale, loc.Z*scale, loc.X*scale+scale, loc.Z*scale+scale)
+	cc, err := render.GetRegionData(s, loc.World, loc.Dimension, rr.DataNeeds, renderOptionsFromLocation(loc), loc.X*scale, loc.Z*scale, loc.X*scale+scale, loc.Z*scale+scale)
 	if err != nil {
 		return nil, err
 	}
 	if len(cc) == 0 {
 		return nil, nil
 	}
-	for _, c := range cc {
-		// TODO: break on cancel
-		placex := int(c.X - offsetx)
-		placey := int(c.Z - offsety)
-		var chunk *image.RGBA
-		chunk = func(d interface{}) *image.RGBA {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Println(loc.X, loc.Z, err) // TODO: pass error outwards
-					debug.PrintStack()
-				}
-				chunk = nil
-			}()
-			var ret *image.RGBA
-			ret = nil
-			ret = painter(d)
-			return ret
-		}(c.Data)
-		if chunk == nil {
+	paintChunks(img, rr, cc, offsetx, offsety, imagescale)
+	return img, nil
+}
+
+// Tiles above cache storage level are put together from four tiles of
+// scale below (taken from cache or rendered on miss) and averaged down
+// instead of drawing every chunk in the area.
+func renderDownscaledTile(loc primitives.ImageLocation, ignoreCache bool) (*image.RGBA, error) {
+	var children [4]*image.RGBA
+	size := 0
+	for i := range children {
+		child := loc
+		child.S--
+		child.X = loc.X*2 + i%2
+		child.Z = loc.Z*2 + i/2
+		img, err := imageGetSync(child, ignoreCache)
+		if err != nil {
+			return nil, err
+		}
+		if img != nil {
+			children[i] = img
+			size = img.Rect.Dx()
+		}
+	}
+	if size == 0 {
+		return nil, nil
+	}
+	full := image.NewRGBA(image.Rect(0, 0, size*2, size*2))
+	for i, c := range children {
+		if c == nil {
 			continue
 		}
-		tile := resize.Resize(uint(imagescale), uint(imagescale), chunk, resize.NearestNeighbor)
-		draw.Draw(img, image.Rect(placex*int(imagescale), placey*int(imagescale), placex*int(imagescale)+imagescale, placey*int(imagescale)+imagescale),
-			tile, image.Pt(0, 0), draw.Over)
+		x, z := i%2*size, i/2*size
+		draw.Draw(full, image.Rect(x, z, x+size, z+size), c, c.Rect.Min, draw.Src)
 	}
-	return img, nil
+	return downsampleRGBA(full), nil
 }
 
-func findTTypeProviderFunc(loc primitives.ImageLocation) *ttypeProviderFunc {
-	for tt := range ttypes {
-		if tt.Name == loc.Variant {
-			f := ttypes[tt]
-			return &f // TODO: fix this ugly thing
+// halves image averaging each 2x2 block, colors are premultiplied
+// so transparent pixels don't darken edges
+func downsampleRGBA(img *image.RGBA) *image.RGBA {
+	w, h := img.Rect.Dx()/2, img.Rect.Dy()/2
+	ret := image.NewRGBA(image.Rect(0, 0, w, h))
+	for y := 0; y < h; y++ {
+		for x := 0; x < w; x++ {
+			i := img.PixOffset(img.Rect.Min.X+x*2, img.Rect.Min.Y+y*2)
+			o := ret.PixOffset(x, y)
+			for c := 0; c < 4; c++ {
+				sum := int(img.Pix[i+c]) + int(img.Pix[i+4+c]) + int(img.Pix[i+img.Stride+c]) + int(img.Pix[i+img.Stride+4+c])
+				ret.Pix[o+c] = uint8((sum + 2) / 4)
+			}
 		}
 	}
-	return nil
+	return ret
+}
+
+// Paints chunks on img in parallel, chunk at X Z goes to X-offsetx Z-offsetz
+// times imagescale.
+func paintChunks(img *image.RGBA, rr *render.ChunkRenderer, cc []render.PositionedChunkData, offsetx, offsetz, imagescale int) {
+	workers := renderWorkers()
+	if workers > len(cc) {
+		workers = len(cc)
+	}
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			defer wg.Done()
+			for {
+				i := int(next.Add(1) - 1)
+				if i >= len(cc) {
+					return
+				}
+				c := cc[i]
+				chunk := renderChunkSafe(rr, c)
+				if chunk == nil {
+					continue
+				}
+				var tile image.Image = chunk
+				if chunk.Rect.Dx() != imagescale {
+					tile = resize.Resize(uint(imagescale), uint(imagescale), chunk, resize.NearestNeighbor)
+				}
+				placex, placez := (c.X-offsetx)*imagescale, (c.Z-offsetz)*imagescale
+				draw.Draw(img, image.Rect(placex, placez, placex+imagescale, placez+imagescale), tile, image.Pt(0, 0), draw.Over)
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func renderChunkSafe(rr *render.ChunkRenderer, c render.PositionedChunkData) (ret *image.RGBA) {
+	defer func() {
+		if err := recover(); err != nil {
+			log.Println(c.X, c.Z, err) // TODO: pass error outwards
+			debug.PrintStack()
+			ret = nil
+		}
+	}()
+	return rr.Render(c.Data)
+}
+
+func drawsWholeTile(variant string) bool {
+	rr := renderers.Get(variant)
+	return rr != nil && rr.RenderTile != nil
 }
@@ -1,8 +1,14 @@
 package main
 
 import (
+	"errors"
+	"fmt"
 	"image"
+	"net/http"
+	"strconv"
+	"time"
 
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
 	"github.com/maxsupermanhd/WebChunk/primitives"
 )
 
@@ -10,6 +16,19 @@ func imageCacheGetBlockingLoc(loc primitives.ImageLocation) *image.RGBA {
 	return ic.GetCachedImageBlocking(loc).Img
 }
 
+// cached image is not returned when chunks it shows changed after it was
+// drawn or it's age colors expired, stale tells that happened
+func imageCacheGetFreshLoc(loc primitives.ImageLocation) (img *image.RGBA, stale bool) {
+	c := ic.GetCachedImageBlocking(loc)
+	if c.Img == nil {
+		return nil, false
+	}
+	if tileChanges.Stale(loc, c.ModTime) || ageTileExpired(loc, c.ModTime) {
+		return nil, true
+	}
+	return c.Img, false
+}
+
 func imageCacheGetBlocking(wname, dname, variant string, cs, cx, cz int) *image.RGBA {
 	return ic.GetCachedImageBlocking(primitives.ImageLocation{
 		World:     wname,
@@ -21,8 +40,9 @@ func imageCacheGetBlocking(wname, dname, variant string, cs, cx, cz int) *image.
 	}).Img
 }
 
-func imageCacheSaveLoc(img *image.RGBA, loc primitives.ImageLocation) {
-	ic.SetCachedImage(loc, img)
+// drawnAt is when rendering started, chunks changed after that make image stale
+func imageCacheSaveLoc(img *image.RGBA, loc primitives.ImageLocation, drawnAt time.Time) {
+	ic.SetCachedImageModTime(loc, img, drawnAt)
 }
 
 func imageCacheSave(img *image.RGBA, wname, dname, variant string, cs, cx, cz int) {
@@ -33,5 +53,57 @@ func imageCacheSave(img *image.RGBA, wname, dname, variant string, cs, cx, cz in
 		S:         cs,
 		X:         cx,
 		Z:         cz,
-	})
+	}, time.Now())
+}
+
+// parameters world, dim, variant, s and area x0 z0 x1 z1 (chunks, end
+// exclusive) narrow down what is purged, all cached images go without them
+func apiPurgeImageCache(w http.ResponseWriter, r *http.Request) (int, string) {
+	if r.ParseForm() != nil {
+		return 400, "Unable to parse form parameters"
+	}
+	f := imagecache.PurgeFilter{
+		World:     r.Form.Get("world"),
+		Dimension: r.Form.Get("dim"),
+		Variant:   r.Form.Get("variant"),
+	}
+	if f.World != "" && !worldNameRegexp.MatchString(f.World) {
+		return 400, "Invalid world name"
+	}
+	if f.Dimension != "" && !dimNameRegexp.MatchString(f.Dimension) {
+		return 400, "Invalid dimension name"
+	}
+	var err error
+	if r.Form.Has("s") {
+		f.HasS = true
+		if f.S, err = strconv.Atoi(r.Form.Get("s")); err != nil {
+			return 400, "Bad s: " + err.Error()
+		}
+		if f.S != 0 && f.S < imagecache.StorageLevel {
+			// cut from storage level tiles, nothing stored at these scales
+			return 400, fmt.Sprintf("Bad s: tiles below scale %d are not stored, purge scale %d instead", imagecache.StorageLevel, imagecache.StorageLevel)
+		}
+	}
+	area := []*int{&f.X0, &f.Z0, &f.X1, &f.Z1}
+	for i, k := range []string{"x0", "z0", "x1", "z1"} {
+		if !r.Form.Has(k) {
+			continue
+		}
+		f.HasArea = true
+		if *area[i], err = strconv.Atoi(r.Form.Get(k)); err != nil {
+			return 400, "Bad " + k + ": " + err.Error()
+		}
+	}
+	if f.HasArea && !(r.Form.Has("x0") && r.Form.Has("z0") && r.Form.Has("x1") && r.Form.Has("z1")) {
+		return 400, "Area needs x0, z0, x1 and z1"
+	}
+	removed, err := ic.Purge(f)
+	if errors.Is(err, imagecache.ErrBadPurgeFilter) {
+		return 400, err.Error()
+	}
+	if err != nil {
+		return 500, "Failed to purge image cache: " + err.Error()
+	}
+	setContentTypeJson(w)
+	return marshalOrFail(200, map[string]int{"Removed": removed})
 }
@@ -29,6 +29,9 @@ const (
 	DefaultIOTasksQueueLen = int(256)
 )
 
+// tiles of negative scale are parts of a chunk with each block 2^-S pixels wide
+const MinScale = int(-4)
+
 func AT(cx, cz int) (int, int) {
 	return cx >> StorageLevel, cz >> StorageLevel
 }
@@ -48,9 +51,10 @@ type CachedImage struct {
 }
 
 type cacheTask struct {
-	loc primitives.ImageLocation
-	img *image.RGBA
-	ret chan *CachedImage
+	loc     primitives.ImageLocation
+	img     *image.RGBA
+	modTime time.Time
+	ret     chan *CachedImage
 }
 
 type ImageCache struct {
@@ -63,6 +67,8 @@ type ImageCache struct {
 	ioReturn            chan *cacheTaskIO
 	cache               map[primitives.ImageLocation]*CachedImage
 	cacheReturn         map[primitives.ImageLocation][]*cacheTask
+	removals            chan *removeTask
+	purgedLoads         map[primitives.ImageLocation]bool
 	backlog             *list.List
 	wg                  sync.WaitGroup
 	cacheStatLen        atomic.Int64
@@ -86,6 +92,8 @@ func NewImageCache(logger *log.Logger, cfg *lac.ConfSubtree, ctx context.Context
 		ioReturn:    make(chan *cacheTaskIO, ioQueueLen),
 		cache:       map[primitives.ImageLocation]*CachedImage{},
 		cacheReturn: map[primitives.ImageLocation][]*cacheTask{},
+		removals:    make(chan *removeTask),
+		purgedLoads: map[primitives.ImageLocation]bool{},
 		backlog:     list.New(),
 	}
 	c.wg.Add(ioProcessors)
@@ -116,6 +124,8 @@ processorLoop:
 			c.processTask(task)
 		case ret := <-c.ioReturn:
 			c.processReturn(ret)
+		case r := <-c.removals:
+			c.processRemove(r)
 		case <-autosaveTimer.C:
 			c.processSave()
 		case <-unloadTimer.C:
@@ -155,17 +165,8 @@ func (c *ImageCache) processTask(task *cacheTask) {
 }
 
 func (c *ImageCache) processImageGet(task *cacheTask) {
-	if task.loc.S > StorageLevel {
-		c.logger.Printf("Requested larger than storage level get (%s)", task.loc.String())
-		task.ret <- &CachedImage{
-			Img:     nil,
-			Loc:     task.loc,
-			lastUse: time.Time{},
-			ModTime: time.Time{},
-		}
-		return
-	}
-	if task.loc.S == StorageLevel {
+	// tiles above storage level are stored whole same as native ones
+	if task.loc.S >= StorageLevel {
 		c.processNativeImageGet(task)
 	} else { // task.loc.S < StorageLevel
 		c.processSmallerImageGet(task)
@@ -231,7 +232,12 @@ func (c *ImageCache) processSmallerImageGet(task *cacheTask) {
 }
 
 func getStorageLevelLoc(loc primitives.ImageLocation) primitives.ImageLocation {
-	rx, rz := AT(loc.X*powarr[loc.S], loc.Z*powarr[loc.S])
+	var rx, rz int
+	if loc.S < 0 {
+		rx, rz = AT(loc.X>>-loc.S, loc.Z>>-loc.S)
+	} else {
+		rx, rz = AT(loc.X*powarr[loc.S], loc.Z*powarr[loc.S])
+	}
 	return primitives.ImageLocation{
 		World:     loc.World,
 		Dimension: loc.Dimension,
@@ -239,6 +245,12 @@ func getStorageLevelLoc(loc primitives.ImageLocation) primitives.ImageLocation {
 		S:         StorageLevel,
 		X:         rx,
 		Z:         rz,
+		Y:         loc.Y,
+		HasY:      loc.HasY,
+		Since:     loc.Since,
+		Query:     loc.Query,
+		Hillshade: loc.Hillshade,
+		AgeHours:  loc.AgeHours,
 	}
 }
 
@@ -257,12 +269,36 @@ func copyFragmentRGBA(from *image.RGBA, target primitives.ImageLocation) *image.
 	if from == nil {
 		return nil
 	}
+	if target.S < 0 {
+		return MagnifyFragment(from, StorageLevel, target)
+	}
 	ax, az := IN(target.X*powarr[target.S], target.Z*powarr[target.S])
 	to := image.NewRGBA(image.Rect(0, 0, powarr16[target.S], powarr16[target.S]))
 	draw.DrawMask(to, to.Rect, from, image.Point{X: ax * 16, Y: az * 16}, nil, image.Point{}, draw.Src)
 	return to
 }
 
+// MagnifyFragment crops area tile of negative scale target covers from image
+// of tile of scale fromS (at most StorageLevel) containing it and scales it up
+// to 16x16 so each block is 2^-target.S pixels wide
+func MagnifyFragment(from *image.RGBA, fromS int, target primitives.ImageLocation) *image.RGBA {
+	if from == nil {
+		return nil
+	}
+	k := -target.S
+	m := 1 << k
+	mask := 16<<fromS - 1
+	ox, oz := (target.X<<4>>k)&mask, (target.Z<<4>>k)&mask
+	to := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			s := from.PixOffset(from.Rect.Min.X+ox+x/m, from.Rect.Min.Y+oz+y/m)
+			copy(to.Pix[to.PixOffset(x, y):], from.Pix[s:s+4])
+		}
+	}
+	return to
+}
+
 func copyCachedImage(img *CachedImage) *CachedImage {
 	return &CachedImage{
 		Img:           copyRGBA(img.Img),
@@ -285,7 +321,7 @@ func copyRGBA(from *image.RGBA) *image.RGBA {
 }
 
 func (c *ImageCache) processImageSet(task *cacheTask) {
-	if task.loc.S != StorageLevel && task.loc.S != 0 {
+	if task.loc.S < StorageLevel && task.loc.S != 0 {
 		c.logger.Printf("Set of non-native and non-zero scaled image %s", task.loc.String())
 		return
 	}
@@ -296,9 +332,13 @@ func (c *ImageCache) processImageSet(task *cacheTask) {
 			img: nil,
 			err: nil,
 		}
+		loc := task.loc
+		if loc.S < StorageLevel {
+			loc = getStorageLevelLoc(loc)
+		}
 		t = &CachedImage{
 			Img:           image.NewRGBA(image.Rect(0, 0, 512, 512)),
-			Loc:           getStorageLevelLoc(task.loc),
+			Loc:           loc,
 			lastUse:       time.Now(),
 			imageUnloaded: true,
 		}
@@ -314,16 +354,27 @@ func (c *ImageCache) processImageSet(task *cacheTask) {
 		rx, rz := IN(task.loc.X, task.loc.Z)
 		r := image.Rect(rx*16, rz*16, rx*16+16, rz*16+16)
 		draw.Draw(t.Img, r, task.img, image.Point{}, draw.Src)
-	} else if task.loc.S == StorageLevel {
+	} else {
 		draw.Draw(t.Img, t.Img.Rect, task.img, image.Point{}, draw.Src)
+		t.ModTime = task.modTime
 	}
 }
 
 func (c *ImageCache) processReturn(task *cacheTaskIO) {
 	if task.err != nil {
 		c.logger.Printf("Error reading image at %s", task.loc.String())
+		delete(c.purgedLoads, task.loc)
 		return
 	}
+	if c.purgedLoads[task.loc] {
+		// file was purged while being read
+		delete(c.purgedLoads, task.loc)
+		task.img = &CachedImage{
+			Loc:          task.loc,
+			SyncedToDisk: true,
+			lastUse:      time.Now(),
+		}
+	}
 	t, ok := c.cache[task.loc]
 	if !ok {
 		c.cache[task.loc] = task.img
@@ -355,6 +406,8 @@ func (c *ImageCache) processCacheLoad(t *CachedImage, task *cacheTaskIO) {
 	}
 	if t.Img != nil {
 		draw.Draw(task.img.Img, task.img.Img.Bounds(), t.Img, image.Point{}, draw.Src)
+	} else {
+		t.ModTime = task.img.ModTime
 	}
 	t.Img = task.img.Img
 	t.imageUnloaded = false
@@ -362,13 +415,20 @@ func (c *ImageCache) processCacheLoad(t *CachedImage, task *cacheTaskIO) {
 }
 
 func (c *ImageCache) SetCachedImage(loc primitives.ImageLocation, img *image.RGBA) {
+	c.SetCachedImageModTime(loc, img, time.Now())
+}
+
+// SetCachedImageModTime stores image drawn from data read at modTime,
+// saved file gets it as modification time so it survives reloads
+func (c *ImageCache) SetCachedImageModTime(loc primitives.ImageLocation, img *image.RGBA, modTime time.Time) {
 	if img == nil {
 		return // dumbass
 	}
 	c.tasks <- &cacheTask{
-		loc: loc,
-		img: img,
-		ret: nil,
+		loc:     loc,
+		img:     img,
+		modTime: modTime,
+		ret:     nil,
 	}
 }
 
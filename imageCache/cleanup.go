@@ -0,0 +1,105 @@
+package imagecache
+
+import (
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// files are removed in batches so processor is not blocked for long
+const removeBatchLen = 1024
+
+type removeTask struct {
+	files []string
+	// purged images are dropped from memory and their files removed
+	// even if they have unsaved changes or are being loaded
+	purge func(primitives.ImageLocation) bool
+	ret   chan int
+}
+
+// RemoveUnmodifiedSince deletes cached image files last written before given
+// time. Files of images held in memory with unsaved changes or waiting for
+// load are kept, synced images are dropped from memory with their files.
+func (c *ImageCache) RemoveUnmodifiedSince(before time.Time) (int, error) {
+	batch := []string{}
+	removed := 0
+	err := filepath.WalkDir(c.root, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".png") {
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return nil
+		}
+		if !info.ModTime().Before(before) {
+			return nil
+		}
+		batch = append(batch, p)
+		if len(batch) >= removeBatchLen {
+			removed += c.removeFiles(batch)
+			batch = []string{}
+		}
+		return c.ctx.Err()
+	})
+	if len(batch) > 0 {
+		removed += c.removeFiles(batch)
+	}
+	return removed, err
+}
+
+func (c *ImageCache) removeFiles(files []string) int {
+	ret := make(chan int, 1)
+	select {
+	case c.removals <- &removeTask{files: files, ret: ret}:
+	case <-c.ctx.Done():
+		return 0
+	}
+	return <-ret
+}
+
+func (c *ImageCache) processRemove(task *removeTask) {
+	keep := map[string]bool{}
+	loaded := map[string]primitives.ImageLocation{}
+	if task.purge != nil {
+		c.processPurge(task.purge)
+	} else {
+		for k, v := range c.cache {
+			fp := filepath.Clean(c.cacheGetFilenameLoc(k))
+			if !v.SyncedToDisk || v.imageUnloaded {
+				keep[fp] = true
+			} else {
+				loaded[fp] = k
+			}
+		}
+		for k := range c.cacheReturn {
+			keep[filepath.Clean(c.cacheGetFilenameLoc(k))] = true
+		}
+	}
+	removed := 0
+	for _, f := range task.files {
+		f = filepath.Clean(f)
+		if keep[f] {
+			continue
+		}
+		if err := os.Remove(f); err != nil && !os.IsNotExist(err) {
+			c.logger.Printf("Failed to remove cached image %s: %v", f, err)
+			continue
+		}
+		if k, ok := loaded[f]; ok {
+			delete(c.cache, k)
+		}
+		removed++
+	}
+	c.cacheStatLen.Store(int64(len(c.cache)))
+	task.ret <- removed
+}
@@ -1,6 +1,8 @@
 package imagecache
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"image"
 	"image/draw"
 	"image/png"
@@ -23,7 +25,7 @@ func (c *ImageCache) processorIO(in <-chan *cacheTaskIO, out chan<- *cacheTaskIO
 		if task.img == nil {
 			task.img, task.err = c.cacheLoad(task.loc)
 		} else {
-			task.err = c.cacheSave(task.img.Img, task.loc)
+			task.err = c.cacheSave(task.img.Img, task.img.ModTime, task.loc)
 		}
 		out <- task
 	}
@@ -35,7 +37,7 @@ func (c *ImageCache) processSave() {
 		if v.SyncedToDisk {
 			continue
 		}
-		err := c.cacheSave(v.Img, k)
+		err := c.cacheSave(v.Img, v.ModTime, k)
 		if err != nil {
 			c.logger.Printf("Failed to save cache of %s (%s): %v", k.String(), c.cacheGetFilenameLoc(k), err)
 			continue
@@ -53,10 +55,27 @@ func (c *ImageCache) cacheGetFilename(world, dim, variant string, s, x, z int) s
 }
 
 func (c *ImageCache) cacheGetFilenameLoc(loc primitives.ImageLocation) string {
-	return c.cacheGetFilename(loc.World, loc.Dimension, loc.Variant, loc.S, loc.X, loc.Z)
+	variant := loc.Variant
+	if loc.HasY {
+		variant += "@y" + strconv.FormatInt(int64(loc.Y), 10)
+	}
+	if loc.Since != 0 {
+		variant += "@since" + strconv.FormatInt(loc.Since, 10)
+	}
+	if loc.Query != "" {
+		h := sha256.Sum256([]byte(loc.Query))
+		variant += "@q" + hex.EncodeToString(h[:8])
+	}
+	if loc.Hillshade != (primitives.Hillshade{}) {
+		variant += "@hs" + strconv.Itoa(loc.Hillshade.Azimuth) + "_" + strconv.Itoa(loc.Hillshade.Altitude) + "_" + strconv.FormatFloat(loc.Hillshade.Exaggeration, 'f', -1, 64)
+	}
+	if loc.AgeHours != 0 {
+		variant += "@age" + strconv.Itoa(loc.AgeHours)
+	}
+	return c.cacheGetFilename(loc.World, loc.Dimension, variant, loc.S, loc.X, loc.Z)
 }
 
-func (c *ImageCache) cacheSave(img *image.RGBA, loc primitives.ImageLocation) error {
+func (c *ImageCache) cacheSave(img *image.RGBA, modTime time.Time, loc primitives.ImageLocation) error {
 	storePath := c.cacheGetFilenameLoc(loc)
 	err := os.MkdirAll(path.Dir(storePath), 0764)
 	if err != nil {
@@ -70,7 +89,11 @@ func (c *ImageCache) cacheSave(img *image.RGBA, loc primitives.ImageLocation) er
 	if err != nil {
 		return err
 	}
-	return file.Close()
+	err = file.Close()
+	if err != nil || modTime.IsZero() {
+		return err
+	}
+	return os.Chtimes(storePath, modTime, modTime)
 }
 
 func (c *ImageCache) cacheLoad(loc primitives.ImageLocation) (*CachedImage, error) {
@@ -0,0 +1,186 @@
+package imagecache
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+var ErrBadPurgeFilter = errors.New("bad purge filter")
+
+// PurgeFilter selects cached images, empty fields match anything
+type PurgeFilter struct {
+	World     string
+	Dimension string
+	// also matches composite variants having it as a layer
+	Variant string
+	// scale is matched only when HasS is set
+	S    int
+	HasS bool
+	// tiles overlapping chunk area [X0, X1) [Z0, Z1), only when HasArea is set
+	X0, Z0, X1, Z1 int
+	HasArea        bool
+}
+
+func (f PurgeFilter) Match(loc primitives.ImageLocation) bool {
+	if f.World != "" && f.World != loc.World {
+		return false
+	}
+	if f.Dimension != "" && f.Dimension != loc.Dimension {
+		return false
+	}
+	if f.Variant != "" && !variantHasLayer(loc.Variant, f.Variant) {
+		return false
+	}
+	if f.HasS && f.S != loc.S {
+		return false
+	}
+	if f.HasArea {
+		x0, z0, x1, z1 := loc.X, loc.Z, loc.X+1, loc.Z+1
+		if loc.S > 0 {
+			x0, z0, x1, z1 = x0<<loc.S, z0<<loc.S, x1<<loc.S, z1<<loc.S
+		} else if loc.S < 0 {
+			x0, z0 = loc.X>>-loc.S, loc.Z>>-loc.S
+			x1, z1 = x0+1, z0+1
+		}
+		if x1 <= f.X0 || x0 >= f.X1 || z1 <= f.Z0 || z0 >= f.Z1 {
+			return false
+		}
+	}
+	return true
+}
+
+// composite variants are layer names joined with + each with optional ~opacity
+func variantHasLayer(variant, layer string) bool {
+	if variant == layer {
+		return true
+	}
+	for _, l := range strings.Split(variant, "+") {
+		if i := strings.IndexByte(l, '~'); i >= 0 {
+			l = l[:i]
+		}
+		if l == layer {
+			return true
+		}
+	}
+	return false
+}
+
+// Purge deletes cached images matching filter from memory and disk,
+// including ones with unsaved changes. Images being loaded at the time
+// are discarded when IO returns so waiting gets see them as not cached.
+// Images drawn while purge is running may be dropped too.
+func (c *ImageCache) Purge(f PurgeFilter) (int, error) {
+	for _, n := range []string{f.World, f.Dimension} {
+		if n == "." || n == ".." || strings.ContainsAny(n, `/\`) {
+			return 0, ErrBadPurgeFilter
+		}
+	}
+	if f.HasArea && (f.X1 <= f.X0 || f.Z1 <= f.Z0) {
+		return 0, ErrBadPurgeFilter
+	}
+	root := path.Join(".", c.root)
+	dir := root
+	if f.World != "" {
+		dir = path.Join(dir, f.World)
+		if f.Dimension != "" {
+			dir = path.Join(dir, f.Dimension)
+		}
+	}
+	batch := []string{}
+	removed := 0
+	sent := false
+	err := filepath.WalkDir(dir, func(p string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() || !strings.HasSuffix(d.Name(), ".png") {
+			return nil
+		}
+		rel, err := filepath.Rel(root, p)
+		if err != nil {
+			return nil
+		}
+		loc, ok := parseCacheFilename(filepath.ToSlash(rel))
+		if !ok || !f.Match(loc) {
+			return nil
+		}
+		batch = append(batch, p)
+		if len(batch) >= removeBatchLen {
+			removed += c.purgeFiles(batch, f)
+			sent = true
+			batch = []string{}
+		}
+		return c.ctx.Err()
+	})
+	if len(batch) > 0 || !sent {
+		removed += c.purgeFiles(batch, f)
+	}
+	return removed, err
+}
+
+func (c *ImageCache) purgeFiles(files []string, f PurgeFilter) int {
+	ret := make(chan int, 1)
+	select {
+	case c.removals <- &removeTask{files: files, purge: f.Match, ret: ret}:
+	case <-c.ctx.Done():
+		return 0
+	}
+	return <-ret
+}
+
+// drops matching images from memory, pending loads are discarded on return,
+// that includes loads started by sets which have no waiting gets
+func (c *ImageCache) processPurge(match func(primitives.ImageLocation) bool) {
+	for k, v := range c.cache {
+		if match(k) {
+			if v.imageUnloaded {
+				c.purgedLoads[k] = true
+			}
+			delete(c.cache, k)
+		}
+	}
+	for k := range c.cacheReturn {
+		if match(k) {
+			c.purgedLoads[k] = true
+		}
+	}
+}
+
+// reverse of cacheGetFilenameLoc relative to root, only location
+// fields purge filter looks at are filled
+func parseCacheFilename(p string) (primitives.ImageLocation, bool) {
+	loc := primitives.ImageLocation{}
+	parts := strings.Split(p, "/")
+	if len(parts) != 5 {
+		return loc, false
+	}
+	loc.World = parts[0]
+	loc.Dimension = parts[1]
+	loc.Variant, _, _ = strings.Cut(parts[2], "@")
+	s, err := strconv.Atoi(parts[3])
+	if err != nil {
+		return loc, false
+	}
+	loc.S = s
+	xs, zs, ok := strings.Cut(strings.TrimSuffix(parts[4], ".png"), "x")
+	if !ok {
+		return loc, false
+	}
+	if loc.X, err = strconv.Atoi(xs); err != nil {
+		return loc, false
+	}
+	if loc.Z, err = strconv.Atoi(zs); err != nil {
+		return loc, false
+	}
+	return loc, true
+}
@@ -0,0 +1,120 @@
+package imagecache
+
+import (
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// CachedVariants lists locations (without scale and coordinates) of
+// variants having cached images in dimension. Variants drawn for block
+// query are left out since query can not be recovered from the hash.
+func (c *ImageCache) CachedVariants(world, dim string) ([]primitives.ImageLocation, error) {
+	entries, err := os.ReadDir(path.Join(".", c.root, world, dim))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ret := []primitives.ImageLocation{}
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+		loc, ok := parseVariantDir(e.Name())
+		if !ok {
+			continue
+		}
+		loc.World = world
+		loc.Dimension = dim
+		ret = append(ret, loc)
+	}
+	return ret, nil
+}
+
+// reverse of variant part of cacheGetFilenameLoc
+func parseVariantDir(name string) (primitives.ImageLocation, bool) {
+	parts := strings.Split(name, "@")
+	loc := primitives.ImageLocation{Variant: parts[0]}
+	for _, p := range parts[1:] {
+		var err error
+		switch {
+		case strings.HasPrefix(p, "age"):
+			loc.AgeHours, err = strconv.Atoi(strings.TrimPrefix(p, "age"))
+		case strings.HasPrefix(p, "since"):
+			loc.Since, err = strconv.ParseInt(strings.TrimPrefix(p, "since"), 10, 64)
+		case strings.HasPrefix(p, "y"):
+			loc.Y, err = strconv.Atoi(strings.TrimPrefix(p, "y"))
+			loc.HasY = true
+		case strings.HasPrefix(p, "hs"):
+			hs := strings.Split(strings.TrimPrefix(p, "hs"), "_")
+			if len(hs) != 3 {
+				return loc, false
+			}
+			if loc.Hillshade.Azimuth, err = strconv.Atoi(hs[0]); err != nil {
+				return loc, false
+			}
+			if loc.Hillshade.Altitude, err = strconv.Atoi(hs[1]); err != nil {
+				return loc, false
+			}
+			loc.Hillshade.Exaggeration, err = strconv.ParseFloat(hs[2], 64)
+		default:
+			return loc, false
+		}
+		if err != nil {
+			return loc, false
+		}
+	}
+	return loc, loc.Variant != ""
+}
+
+// StorageLevelLocation is location of tile of StorageLevel scale smaller
+// tiles at loc are cut out of
+func StorageLevelLocation(loc primitives.ImageLocation) primitives.ImageLocation {
+	return getStorageLevelLoc(loc)
+}
+
+// CachedVariantDirs lists all variant directories of dimension including
+// ones with block query, variant name is the part before first @
+func (c *ImageCache) CachedVariantDirs(world, dim string) ([]string, error) {
+	entries, err := os.ReadDir(path.Join(".", c.root, world, dim))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	ret := []string{}
+	for _, e := range entries {
+		if e.IsDir() {
+			ret = append(ret, e.Name())
+		}
+	}
+	return ret, nil
+}
+
+// DirTileModTime returns modification time of tile saved in variant
+// directory, zero if it is not saved
+func (c *ImageCache) DirTileModTime(world, dim, dir string, s, x, z int) time.Time {
+	return c.getModTimeFp(c.cacheGetFilename(world, dim, dir, s, x, z))
+}
+
+// DirScales lists scales having saved tiles in variant directory
+func (c *ImageCache) DirScales(world, dim, dir string) []int {
+	entries, err := os.ReadDir(path.Join(".", c.root, world, dim, dir))
+	if err != nil {
+		return nil
+	}
+	ret := []int{}
+	for _, e := range entries {
+		if s, err := strconv.Atoi(e.Name()); err == nil && e.IsDir() {
+			ret = append(ret, s)
+		}
+	}
+	return ret
+}
@@ -1,3 +1,5 @@
+// Deprecated: import job of the web server imports region files directly
+// into the storage, this tool is not maintained.
 package main
 
 import (
@@ -71,10 +71,6 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 			// log.Println("Skipping storage " + s.Name + " because driver is uninitialized")
 			continue
 		}
-		achunksCount, _ := s.Driver.GetChunksCount()
-		achunksSizeBytes, _ := s.Driver.GetChunksSize()
-		chunksCount += achunksCount
-		chunksSizeBytes += achunksSizeBytes
 		worldss, err := s.Driver.ListWorlds()
 		if err != nil {
 			plainmsg(w, r, plainmsgColorRed, "Error listing worlds of storage "+sn+": "+err.Error())
@@ -88,16 +84,14 @@ func indexHandler(w http.ResponseWriter, r *http.Request) {
 				return
 			}
 			for _, dim := range dims {
-				dimChunksCount, err := s.Driver.GetDimensionChunksCount(wrld.Name, dim.Name)
+				stats, err := getDimStats(sn, s.Driver, wrld.Name, dim.Name)
 				if err != nil {
-					plainmsg(w, r, plainmsgColorRed, "Error getting chunk count of dim "+dim.Name+" of world "+wrld.Name+" of storage "+sn+": "+err.Error())
-					return
-				}
-				dimChunksSize, err := s.Driver.GetDimensionChunksSize(wrld.Name, dim.Name)
-				if err != nil {
-					plainmsg(w, r, plainmsgColorRed, "Error getting chunks size of dim "+dim.Name+" of world "+wrld.Name+" of storage "+sn+": "+err.Error())
+					plainmsg(w, r, plainmsgColorRed, "Error getting chunk stats of dim "+dim.Name+" of world "+wrld.Name+" of storage "+sn+": "+err.Error())
 					return
 				}
+				dimChunksCount, dimChunksSize := stats.ChunkCount, stats.ChunkSize
+				chunksCount += dimChunksCount
+				chunksSizeBytes += dimChunksSize
 				// dimCacheCount, dimCacheSize, err := getImageCacheCountSize(wrld.Name, dim.Name)
 				dimCacheCount, dimCacheSize, err := int64(0), 0, nil
 				if err != nil {
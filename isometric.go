@@ -0,0 +1,172 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/render"
+)
+
+// Isometric view is looking from south-east and above, block at height y is drawn
+// shifted north-west by (y - isometricBaseY) / 2 pixels, so top, east and south
+// faces are visible while tiles keep one pixel per block at the base height.
+// Only one chunk around is known so relief more than 32 blocks away from
+// base height may get cut on chunk borders.
+const isometricBaseY = 64
+
+const (
+	isometricFaceTop = iota
+	isometricFaceEast
+	isometricFaceSouth
+)
+
+var isometricFaceShade = [3]float64{
+	isometricFaceTop:   1.0,
+	isometricFaceEast:  0.8,
+	isometricFaceSouth: 0.62,
+}
+
+func drawChunkIsometric(d render.ChunkData) *image.RGBA {
+	t := time.Now()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	area := newAreaBlocks(d)
+	tint := renderBiomeTinter(d)
+	top, ok := area.maxY()
+	if !ok {
+		return img
+	}
+	bottom := 0
+	baseY := isometricBaseY
+	if dim := d.GetDimension(); dim != nil && dim.Height > 0 {
+		bottom = int(dim.MinY)
+		if dimTop := int(dim.MinY + dim.Height); top > dimTop {
+			top = dimTop
+		}
+		if baseY < bottom || baseY >= int(dim.MinY+dim.Height) {
+			baseY = bottom + int(dim.Height)/2
+		}
+	}
+	for i := 0; i < 16*16; i++ {
+		c, ok := castIsometricRay(area, tint, i%16, i/16, top, bottom, baseY)
+		if ok {
+			img.Set(i%16, i/16, c)
+		}
+	}
+	appendMetrics(time.Since(t), "isometric")
+	return img
+}
+
+// walks voxels along the view ray (Amanatides & Woo) until it hits something opaque
+func castIsometricRay(area *areaBlocks, tint *biomeTinter, px, pz, top, bottom, baseY int) (color.RGBA64, bool) {
+	// ray leaves the area (x or z below -16) after descending this far under base height,
+	// and enters it (x or z above 32) above this height
+	startY := float64(top)
+	far := px
+	if pz > far {
+		far = pz
+	}
+	if maxStart := float64(baseY) + 2*(32-float64(far)-0.5); startY > maxStart {
+		startY = maxStart
+	}
+	shift := (startY - float64(baseY)) / 2
+	pos := [3]float64{float64(px) + 0.5 + shift, startY - 1e-6, float64(pz) + 0.5 + shift}
+	dir := [3]float64{-0.5, -1, -0.5}
+	cell := [3]int{}
+	tMax := [3]float64{}
+	tDelta := [3]float64{}
+	for a := 0; a < 3; a++ {
+		cell[a] = int(math.Floor(pos[a]))
+		tMax[a] = (pos[a] - float64(cell[a])) / -dir[a]
+		tDelta[a] = 1 / -dir[a]
+	}
+	var water color.RGBA64
+	waterLayers := 0
+	axis := 1
+	for ; cell[1] >= bottom && cell[0] >= -16 && cell[2] >= -16; cell[axis]-- {
+		state := area.get(cell[0], cell[1], cell[2])
+		wasAxis := axis
+		axis = 0
+		if tMax[1] < tMax[axis] {
+			axis = 1
+		}
+		if tMax[2] < tMax[axis] {
+			axis = 2
+		}
+		tMax[axis] += tDelta[axis]
+		if isAirState(state) {
+			continue
+		}
+		c, isTransparent, isWater := blockColor(state)
+		c = tint.apply(state, c, cell[0], cell[1], cell[2])
+		if isWater {
+			if waterLayers == 0 {
+				water = c
+			}
+			waterLayers++
+			continue
+		}
+		if isTransparent {
+			continue
+		}
+		face := isometricFaceTop
+		switch wasAxis {
+		case 0:
+			face = isometricFaceEast
+		case 2:
+			face = isometricFaceSouth
+		}
+		c = shadeColor(c, isometricFaceShade[face])
+		if waterLayers > 0 {
+			c = mixColor(c, shadeColor(water, waterDepthShade(waterLayers)), waterOpacity(waterLayers))
+		}
+		c.A = 65535
+		return c, true
+	}
+	if waterLayers > 0 {
+		water = shadeColor(water, waterDepthShade(waterLayers))
+		water.A = 65535
+		return water, true
+	}
+	return color.RGBA64{}, false
+}
+
+func shadeColor(c color.RGBA64, k float64) color.RGBA64 {
+	return color.RGBA64{
+		R: uint16(float64(c.R) * k),
+		G: uint16(float64(c.G) * k),
+		B: uint16(float64(c.B) * k),
+		A: c.A,
+	}
+}
+
+// k is the weight of the second color
+func mixColor(a, b color.RGBA64, k float64) color.RGBA64 {
+	return color.RGBA64{
+		R: uint16(float64(a.R)*(1-k) + float64(b.R)*k),
+		G: uint16(float64(a.G)*(1-k) + float64(b.G)*k),
+		B: uint16(float64(a.B)*(1-k) + float64(b.B)*k),
+		A: uint16(float64(a.A)*(1-k) + float64(b.A)*k),
+	}
+}
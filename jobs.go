@@ -0,0 +1,574 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gorilla/mux"
+)
+
+type jobState string
+
+const (
+	jobQueued   jobState = "queued"
+	jobRunning  jobState = "running"
+	jobPaused   jobState = "paused"
+	jobDone     jobState = "done"
+	jobFailed   jobState = "failed"
+	jobCanceled jobState = "canceled"
+)
+
+// how often progress of running jobs is broadcasted
+const jobEventInterval = time.Second
+
+var (
+	ErrJobNotFound    = errors.New("job not found")
+	ErrJobUnknownType = errors.New("unknown job type")
+	ErrJobState       = errors.New("not possible in current job state")
+)
+
+// Job as it is persisted and reported over api and events
+type job struct {
+	ID     int
+	Type   string
+	Params json.RawMessage
+	State  jobState
+	// progress in units job type chooses, Total is 0 if not known yet
+	Done, Total int64
+	// last status or error
+	Message string
+	// saved by job to continue where it stopped after pause or restart
+	Checkpoint json.RawMessage `json:",omitempty"`
+	Result     json.RawMessage `json:",omitempty"`
+	// name of schedule entry that created the job
+	Schedule  string `json:",omitempty"`
+	CreatedAt time.Time
+	UpdatedAt time.Time
+
+	cancel    context.CancelFunc
+	stopAs    jobState
+	lastEvent time.Time
+}
+
+type jobType struct {
+	Name        string
+	Description string
+	// Works until done or ctx is canceled, returning ctx error is fine then.
+	// Work done should be recorded with jc.Checkpoint so job can continue.
+	Run func(ctx context.Context, jc *jobContext) error `json:"-"`
+}
+
+var (
+	jobTypesLock sync.Mutex
+	jobTypes     = map[string]jobType{}
+	jobs         = newJobManager()
+)
+
+func registerJobType(t jobType) {
+	jobTypesLock.Lock()
+	defer jobTypesLock.Unlock()
+	if _, ok := jobTypes[t.Name]; ok {
+		log.Fatalf("Job type %q registered twice", t.Name)
+	}
+	jobTypes[t.Name] = t
+}
+
+func getJobType(name string) (jobType, bool) {
+	jobTypesLock.Lock()
+	defer jobTypesLock.Unlock()
+	t, ok := jobTypes[name]
+	return t, ok
+}
+
+func listJobTypes() []jobType {
+	jobTypesLock.Lock()
+	ret := make([]jobType, 0, len(jobTypes))
+	for _, t := range jobTypes {
+		ret = append(ret, t)
+	}
+	jobTypesLock.Unlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
+
+// Handle given to running job
+type jobContext struct {
+	m  *jobManager
+	id int
+}
+
+func (jc *jobContext) Params(v any) error {
+	jc.m.lock.Lock()
+	p := jc.m.jobs[jc.id].Params
+	jc.m.lock.Unlock()
+	if len(p) == 0 {
+		return nil
+	}
+	return json.Unmarshal(p, v)
+}
+
+// loads checkpoint saved before pause or restart, v is left as is if there is none
+func (jc *jobContext) LoadCheckpoint(v any) error {
+	jc.m.lock.Lock()
+	p := jc.m.jobs[jc.id].Checkpoint
+	jc.m.lock.Unlock()
+	if len(p) == 0 {
+		return nil
+	}
+	return json.Unmarshal(p, v)
+}
+
+// loads result set before pause or restart, v is left as is if there is none
+func (jc *jobContext) LoadResult(v any) error {
+	jc.m.lock.Lock()
+	p := jc.m.jobs[jc.id].Result
+	jc.m.lock.Unlock()
+	if len(p) == 0 {
+		return nil
+	}
+	return json.Unmarshal(p, v)
+}
+
+// records and persists work done so far
+func (jc *jobContext) Checkpoint(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	jc.m.update(jc.id, func(j *job) { j.Checkpoint = b })
+	return jc.m.save()
+}
+
+// result is persisted with next checkpoint
+func (jc *jobContext) SetResult(v any) error {
+	b, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	jc.m.update(jc.id, func(j *job) { j.Result = b })
+	return nil
+}
+
+// progress events are rate limited to one per jobEventInterval
+func (jc *jobContext) Progress(done, total int64, msg string) {
+	jc.m.update(jc.id, func(j *job) {
+		j.Done, j.Total, j.Message = done, total, msg
+	})
+}
+
+type jobManager struct {
+	lock   sync.Mutex
+	path   string
+	jobs   map[int]*job
+	nextID int
+	wake   chan struct{}
+	wg     sync.WaitGroup
+	// saves are serialized so older state never overwrites newer one
+	saveLock sync.Mutex
+}
+
+func newJobManager() *jobManager {
+	return &jobManager{
+		jobs:   map[int]*job{},
+		nextID: 1,
+		wake:   make(chan struct{}, 1),
+	}
+}
+
+// Loads persisted jobs and runs queued ones until exit. Jobs running at
+// exit are stopped and queued again so they continue after restart.
+func (m *jobManager) Run(exitchan <-chan struct{}) {
+	m.lock.Lock()
+	m.path = cfg.GetDSString("jobs.json", "jobs_path")
+	m.lock.Unlock()
+	if err := m.load(); err != nil {
+		log.Printf("Failed to load jobs: %s", err.Error())
+	}
+	m.poke()
+	for {
+		select {
+		case <-exitchan:
+			m.lock.Lock()
+			for _, j := range m.jobs {
+				if j.State == jobRunning {
+					j.stopAs = jobQueued
+					j.cancel()
+				}
+			}
+			m.lock.Unlock()
+			m.wg.Wait()
+			if err := m.save(); err != nil {
+				log.Printf("Failed to save jobs: %s", err.Error())
+			}
+			return
+		case <-m.wake:
+			m.startQueued()
+		}
+	}
+}
+
+func (m *jobManager) poke() {
+	select {
+	case m.wake <- struct{}{}:
+	default:
+	}
+}
+
+// starts oldest queued jobs while there are free slots
+func (m *jobManager) startQueued() {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	running := 0
+	queued := []*job{}
+	for _, j := range m.jobs {
+		switch j.State {
+		case jobRunning:
+			running++
+		case jobQueued:
+			queued = append(queued, j)
+		}
+	}
+	sort.Slice(queued, func(a, b int) bool { return queued[a].ID < queued[b].ID })
+	limit := cfg.GetDSInt(1, "jobs_concurrent")
+	for _, j := range queued {
+		if running >= limit {
+			break
+		}
+		t, ok := getJobType(j.Type)
+		if !ok {
+			m.setStateLocked(j, jobFailed, ErrJobUnknownType.Error())
+			continue
+		}
+		ctx, cancel := context.WithCancel(context.Background())
+		j.cancel = cancel
+		j.stopAs = ""
+		m.setStateLocked(j, jobRunning, "")
+		running++
+		m.wg.Add(1)
+		go m.runJob(ctx, j.ID, t)
+	}
+}
+
+func (m *jobManager) runJob(ctx context.Context, id int, t jobType) {
+	defer m.wg.Done()
+	err := func() (err error) {
+		defer func() {
+			if r := recover(); r != nil {
+				log.Printf("Job %d of type %s panicked: %v", id, t.Name, r)
+				err = errors.New("job panicked")
+			}
+		}()
+		return t.Run(ctx, &jobContext{m: m, id: id})
+	}()
+	m.lock.Lock()
+	j := m.jobs[id]
+	j.cancel()
+	j.cancel = nil
+	switch {
+	case j.stopAs != "":
+		m.setStateLocked(j, j.stopAs, j.Message)
+	case err != nil:
+		log.Printf("Job %d of type %s failed: %s", id, t.Name, err.Error())
+		m.setStateLocked(j, jobFailed, err.Error())
+	default:
+		m.setStateLocked(j, jobDone, j.Message)
+	}
+	m.lock.Unlock()
+	if err := m.save(); err != nil {
+		log.Printf("Failed to save jobs: %s", err.Error())
+	}
+	m.poke()
+}
+
+func (m *jobManager) setStateLocked(j *job, s jobState, msg string) {
+	j.State = s
+	j.Message = msg
+	j.UpdatedAt = time.Now()
+	m.broadcastLocked(j)
+}
+
+func (m *jobManager) broadcastLocked(j *job) {
+	j.lastEvent = time.Now()
+	globalEventRouter.Broadcast(mapEvent{
+		Action: "jobUpdate",
+		Data:   *j,
+	})
+}
+
+func (m *jobManager) update(id int, f func(j *job)) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return
+	}
+	f(j)
+	j.UpdatedAt = time.Now()
+	if time.Since(j.lastEvent) >= jobEventInterval {
+		m.broadcastLocked(j)
+	}
+}
+
+// schedule is empty for jobs not created by scheduler
+func (m *jobManager) Add(typ, schedule string, params json.RawMessage) (job, error) {
+	if _, ok := getJobType(typ); !ok {
+		return job{}, ErrJobUnknownType
+	}
+	m.lock.Lock()
+	j := &job{
+		ID:        m.nextID,
+		Type:      typ,
+		Params:    params,
+		Schedule:  schedule,
+		CreatedAt: time.Now(),
+	}
+	m.nextID++
+	m.jobs[j.ID] = j
+	m.setStateLocked(j, jobQueued, "")
+	ret := *j
+	m.lock.Unlock()
+	m.poke()
+	return ret, m.save()
+}
+
+func (m *jobManager) Get(id int) (job, bool) {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	j, ok := m.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+// sorted by id
+func (m *jobManager) List() []job {
+	m.lock.Lock()
+	ret := make([]job, 0, len(m.jobs))
+	for _, j := range m.jobs {
+		ret = append(ret, *j)
+	}
+	m.lock.Unlock()
+	sort.Slice(ret, func(a, b int) bool { return ret[a].ID < ret[b].ID })
+	return ret
+}
+
+// stops running or queued job, it keeps it's checkpoint and can be resumed
+func (m *jobManager) Pause(id int) error {
+	return m.stop(id, jobPaused)
+}
+
+func (m *jobManager) Cancel(id int) error {
+	return m.stop(id, jobCanceled)
+}
+
+func (m *jobManager) stop(id int, as jobState) error {
+	m.lock.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.lock.Unlock()
+		return ErrJobNotFound
+	}
+	switch {
+	case j.State == jobRunning:
+		j.stopAs = as
+		j.cancel()
+		m.lock.Unlock()
+		return nil
+	case j.State == jobQueued, j.State == jobPaused && as == jobCanceled:
+		m.setStateLocked(j, as, j.Message)
+	default:
+		m.lock.Unlock()
+		return ErrJobState
+	}
+	m.lock.Unlock()
+	return m.save()
+}
+
+func (m *jobManager) Resume(id int) error {
+	m.lock.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.lock.Unlock()
+		return ErrJobNotFound
+	}
+	if j.State != jobPaused && j.State != jobFailed {
+		m.lock.Unlock()
+		return ErrJobState
+	}
+	m.setStateLocked(j, jobQueued, j.Message)
+	m.lock.Unlock()
+	m.poke()
+	return m.save()
+}
+
+// removes job that is not running or queued
+func (m *jobManager) Remove(id int) error {
+	m.lock.Lock()
+	j, ok := m.jobs[id]
+	if !ok {
+		m.lock.Unlock()
+		return ErrJobNotFound
+	}
+	if j.State == jobRunning || j.State == jobQueued {
+		m.lock.Unlock()
+		return ErrJobState
+	}
+	delete(m.jobs, id)
+	m.lock.Unlock()
+	return m.save()
+}
+
+func (m *jobManager) load() error {
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	b, err := os.ReadFile(m.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var loaded []*job
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		return err
+	}
+	for _, j := range loaded {
+		// server went down without stopping it
+		if j.State == jobRunning {
+			j.State = jobQueued
+		}
+		m.jobs[j.ID] = j
+		if j.ID >= m.nextID {
+			m.nextID = j.ID + 1
+		}
+	}
+	return nil
+}
+
+func (m *jobManager) save() error {
+	m.saveLock.Lock()
+	defer m.saveLock.Unlock()
+	list := m.List()
+	m.lock.Lock()
+	path := m.path
+	m.lock.Unlock()
+	if path == "" {
+		return nil
+	}
+	b, err := json.MarshalIndent(list, "", "\t")
+	if err != nil {
+		return err
+	}
+	if err := os.WriteFile(path+".tmp", b, 0644); err != nil {
+		return err
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+func jobIDParam(r *http.Request) (int, error) {
+	return strconv.Atoi(mux.Vars(r)["id"])
+}
+
+func jobErrorCode(err error) (int, string) {
+	switch {
+	case errors.Is(err, ErrJobNotFound):
+		return 404, err.Error()
+	case errors.Is(err, ErrJobState), errors.Is(err, ErrJobUnknownType):
+		return 400, err.Error()
+	default:
+		return 500, err.Error()
+	}
+}
+
+func apiListJobs(_ http.ResponseWriter, _ *http.Request) (int, string) {
+	return marshalOrFail(200, jobs.List())
+}
+
+func apiListJobTypes(_ http.ResponseWriter, _ *http.Request) (int, string) {
+	return marshalOrFail(200, listJobTypes())
+}
+
+func apiGetJob(_ http.ResponseWriter, r *http.Request) (int, string) {
+	id, err := jobIDParam(r)
+	if err != nil {
+		return 400, "Bad job id"
+	}
+	j, ok := jobs.Get(id)
+	if !ok {
+		return 404, ErrJobNotFound.Error()
+	}
+	return marshalOrFail(200, j)
+}
+
+// form values type and params (json object)
+func apiAddJob(_ http.ResponseWriter, r *http.Request) (int, string) {
+	if r.ParseMultipartForm(0) != nil && r.ParseForm() != nil {
+		return 400, "Unable to parse form parameters"
+	}
+	var params json.RawMessage
+	if p := r.FormValue("params"); p != "" {
+		if !json.Valid([]byte(p)) {
+			return 400, "Params are not valid json"
+		}
+		params = json.RawMessage(p)
+	}
+	j, err := jobs.Add(r.FormValue("type"), "", params)
+	if err != nil {
+		return jobErrorCode(err)
+	}
+	return marshalOrFail(200, j)
+}
+
+func apiRemoveJob(_ http.ResponseWriter, r *http.Request) (int, string) {
+	id, err := jobIDParam(r)
+	if err != nil {
+		return 400, "Bad job id"
+	}
+	if err := jobs.Remove(id); err != nil {
+		return jobErrorCode(err)
+	}
+	return 200, ""
+}
+
+func apiJobAction(action func(id int) error) func(http.ResponseWriter, *http.Request) (int, string) {
+	return func(_ http.ResponseWriter, r *http.Request) (int, string) {
+		id, err := jobIDParam(r)
+		if err != nil {
+			return 400, "Bad job id"
+		}
+		if err := action(id); err != nil {
+			return jobErrorCode(err)
+		}
+		j, _ := jobs.Get(id)
+		return marshalOrFail(200, j)
+	}
+}
@@ -0,0 +1,306 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/bits"
+	"os"
+	"path"
+	"sort"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/WebChunk/chunkStorage/filesystemChunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/level"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+	"github.com/maxsupermanhd/go-vmc/v764/save/region"
+)
+
+const blockFinderMaxResults = 4096
+
+func init() {
+	for _, t := range []jobType{{
+		Name:        "import",
+		Description: "Import region files from a folder on the server into a dimension",
+		Run:         runImportJob,
+	}, {
+		Name:        "regenheightmaps",
+		Description: "Regenerate WORLD_SURFACE heightmaps of stored chunks in an area",
+		Run:         runRegenHeightmapsJob,
+	}, {
+		Name:        "blockfinder",
+		Description: "Find chunks containing blocks matching a query in an area",
+		Run:         runBlockFinderJob,
+	}} {
+		registerJobType(t)
+	}
+}
+
+// Chunk area of a dimension, end exclusive, processed in 32x32 steps
+type jobArea struct {
+	World     string
+	Dimension string
+	X0, Z0    int
+	X1, Z1    int
+}
+
+func (a jobArea) steps() (int, int) {
+	return (a.X1 - a.X0 + 31) / 32, (a.Z1 - a.Z0 + 31) / 32
+}
+
+func (a jobArea) step(i int) (int, int, int, int) {
+	sx, _ := a.steps()
+	x0, z0 := a.X0+i%sx*32, a.Z0+i/sx*32
+	x1, z1 := x0+32, z0+32
+	if x1 > a.X1 {
+		x1 = a.X1
+	}
+	if z1 > a.Z1 {
+		z1 = a.Z1
+	}
+	return x0, z0, x1, z1
+}
+
+func (a jobArea) storage() (chunkStorage.ChunkStorage, *chunkStorage.SDim, error) {
+	if a.X1 <= a.X0 || a.Z1 <= a.Z0 {
+		return nil, nil, errors.New("empty area")
+	}
+	return getJobDimension(a.World, a.Dimension)
+}
+
+func getJobDimension(wname, dname string) (chunkStorage.ChunkStorage, *chunkStorage.SDim, error) {
+	world, s, err := chunkStorage.GetWorldStorage(storages, wname)
+	if err != nil {
+		return nil, nil, err
+	}
+	if s == nil || world == nil {
+		return nil, nil, errors.New("world not found")
+	}
+	dim, err := s.GetDimension(wname, dname)
+	if err != nil {
+		return nil, nil, err
+	}
+	if dim == nil {
+		return nil, nil, errors.New("dimension not found")
+	}
+	return s, dim, nil
+}
+
+// Calls f for every stored chunk of the area, checkpointing after each step
+func runAreaJob(ctx context.Context, jc *jobContext, a jobArea, f func(s chunkStorage.ChunkStorage, dim *chunkStorage.SDim, x, z int, c *save.Chunk) error) error {
+	s, dim, err := a.storage()
+	if err != nil {
+		return err
+	}
+	next := 0
+	if err := jc.LoadCheckpoint(&next); err != nil {
+		return err
+	}
+	sx, sz := a.steps()
+	for ; next < sx*sz; next++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		jc.Progress(int64(next), int64(sx*sz), fmt.Sprintf("Area step %d of %d", next+1, sx*sz))
+		x0, z0, x1, z1 := a.step(next)
+		cc, err := s.GetChunksRegion(a.World, a.Dimension, x0, z0, x1, z1)
+		if err != nil {
+			return err
+		}
+		for _, c := range cc {
+			chunk, ok := c.Data.(*save.Chunk)
+			if !ok || chunk == nil {
+				continue
+			}
+			if err := f(s, dim, c.X, c.Z, chunk); err != nil {
+				return err
+			}
+		}
+		if err := jc.Checkpoint(next + 1); err != nil {
+			return err
+		}
+	}
+	jc.Progress(int64(sx*sz), int64(sx*sz), "")
+	return nil
+}
+
+type importJobParams struct {
+	// folder with region files on the server
+	Path      string
+	World     string
+	Dimension string
+}
+
+func runImportJob(ctx context.Context, jc *jobContext) error {
+	var p importJobParams
+	if err := jc.Params(&p); err != nil {
+		return err
+	}
+	s, _, err := getJobDimension(p.World, p.Dimension)
+	if err != nil {
+		return err
+	}
+	de, err := os.ReadDir(p.Path)
+	if err != nil {
+		return err
+	}
+	files := []string{}
+	for _, d := range de {
+		var rx, rz int
+		if !d.IsDir() && filesystemChunkStorage.ExtractRegionPath(d.Name(), &rx, &rz) {
+			files = append(files, d.Name())
+		}
+	}
+	sort.Strings(files)
+	next := 0
+	if err := jc.LoadCheckpoint(&next); err != nil {
+		return err
+	}
+	for ; next < len(files); next++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		jc.Progress(int64(next), int64(len(files)), "Importing "+files[next])
+		var rx, rz int
+		filesystemChunkStorage.ExtractRegionPath(files[next], &rx, &rz)
+		r, err := region.Open(path.Join(p.Path, files[next]))
+		if err != nil {
+			log.Printf("Import job failed to open region %s: %s", files[next], err.Error())
+			continue
+		}
+		for x := 0; x < 32; x++ {
+			for z := 0; z < 32; z++ {
+				if !r.ExistSector(x, z) {
+					continue
+				}
+				data, err := r.ReadSector(x, z)
+				if err != nil {
+					log.Printf("Import job failed to read chunk %2d:%2d of region %s: %s", x, z, files[next], err.Error())
+					continue
+				}
+				if err := s.AddChunkRaw(p.World, p.Dimension, rx*32+x, rz*32+z, data); err != nil {
+					r.Close()
+					return err
+				}
+				tileChanges.MarkChunk(p.World, p.Dimension, rx*32+x, rz*32+z)
+			}
+		}
+		r.Close()
+		if err := jc.Checkpoint(next + 1); err != nil {
+			return err
+		}
+	}
+	jc.Progress(int64(len(files)), int64(len(files)), "")
+	return nil
+}
+
+func runRegenHeightmapsJob(ctx context.Context, jc *jobContext) error {
+	var a jobArea
+	if err := jc.Params(&a); err != nil {
+		return err
+	}
+	return runAreaJob(ctx, jc, a, func(s chunkStorage.ChunkStorage, dim *chunkStorage.SDim, x, z int, c *save.Chunk) error {
+		if dim.Data.Height <= 0 {
+			return errors.New("dimension has no height")
+		}
+		ws := level.NewBitStorage(bits.Len(uint(dim.Data.Height+1)), 16*16, nil)
+		height, set := genHeightmapColumns(c, nil)
+		for i, h := range height {
+			// same as vanilla, height of the first air block above the surface, 0 for void columns
+			if set[i] {
+				ws.Set(i, h-int(dim.Data.MinY)+1)
+			}
+		}
+		if c.Heightmaps == nil {
+			c.Heightmaps = map[string][]uint64{}
+		}
+		c.Heightmaps["WORLD_SURFACE"] = ws.Raw()
+		if err := s.AddChunk(a.World, a.Dimension, x, z, *c); err != nil {
+			return err
+		}
+		tileChanges.MarkChunk(a.World, a.Dimension, x, z)
+		return nil
+	})
+}
+
+type blockFinderJobParams struct {
+	jobArea
+	// block query same as in block search layer
+	Blocks string
+}
+
+type blockFinderMatch struct {
+	X, Z  int
+	Count int
+}
+
+func runBlockFinderJob(ctx context.Context, jc *jobContext) error {
+	var p blockFinderJobParams
+	if err := jc.Params(&p); err != nil {
+		return err
+	}
+	nq, err := normalizeBlockQuery(p.Blocks)
+	if err != nil {
+		return err
+	}
+	q, err := getBlockQuery(nq)
+	if err != nil {
+		return err
+	}
+	// results are saved together with area checkpoint
+	found := []blockFinderMatch{}
+	if err := jc.LoadResult(&found); err != nil {
+		return err
+	}
+	return runAreaJob(ctx, jc, p.jobArea, func(_ chunkStorage.ChunkStorage, _ *chunkStorage.SDim, x, z int, c *save.Chunk) error {
+		count := 0
+		for i := range c.Sections {
+			sec := &c.Sections[i]
+			inPalette := false
+			for _, b := range sec.BlockStates.Palette {
+				if q.ids[b.Name] {
+					inPalette = true
+					break
+				}
+			}
+			if !inPalette {
+				continue
+			}
+			states := prepareSectionBlockstates(sec)
+			if states == nil {
+				continue
+			}
+			for j := 0; j < 16*16*16; j++ {
+				if q.states[states.Get(j)] {
+					count++
+				}
+			}
+		}
+		if count > 0 && len(found) < blockFinderMaxResults {
+			found = append(found, blockFinderMatch{X: x, Z: z, Count: count})
+			return jc.SetResult(found)
+		}
+		return nil
+	})
+}
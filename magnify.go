@@ -0,0 +1,92 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// size of magnified tiles drawn by RenderTile, same as web map tile size
+// so labels are drawn with real pixels instead of blown up ones
+const magnifiedRenderTileSize = 256
+
+// Magnified tiles are cut out of chunk image of scale 0 and blown up,
+// chunk image comes from cache or is rendered on miss.
+func renderMagnifiedTile(loc primitives.ImageLocation, ignoreCache bool) (*image.RGBA, error) {
+	base := loc
+	base.S = 0
+	base.X = loc.X >> -loc.S
+	base.Z = loc.Z >> -loc.S
+	img, err := imageGetSync(base, ignoreCache)
+	if err != nil || img == nil {
+		return nil, err
+	}
+	return imagecache.MagnifyFragment(img, 0, loc), nil
+}
+
+// Block borders and texture-like noise for magnified tiles of renderers
+// with BlockDetail. Drawn over already magnified image so cached and
+// freshly rendered tiles look the same.
+func drawBlockDetail(img *image.RGBA, loc primitives.ImageLocation) {
+	if img == nil || loc.S > -2 {
+		return
+	}
+	rr := renderers.Get(loc.Variant)
+	if rr == nil || !rr.BlockDetail {
+		return
+	}
+	k := -loc.S
+	m := 1 << k
+	bx, bz := loc.X<<4>>k, loc.Z<<4>>k
+	for y := 0; y < 16; y++ {
+		for x := 0; x < 16; x++ {
+			o := img.PixOffset(x, y)
+			a := int(img.Pix[o+3])
+			if a == 0 {
+				continue
+			}
+			px, pz := x%m, y%m
+			f := 1 + blockDetailNoise(bx+x/m, bz+y/m, px, pz)*0.06
+			if px == 0 || pz == 0 {
+				f -= 0.15
+			}
+			for c := 0; c < 3; c++ {
+				v := int(float64(img.Pix[o+c]) * f)
+				if v > a {
+					v = a
+				}
+				img.Pix[o+c] = uint8(v)
+			}
+		}
+	}
+}
+
+// stable pseudo-random value in [-1, 1] for pixel px pz of block x z
+func blockDetailNoise(x, z, px, pz int) float64 {
+	h := uint32(x)*73856093 ^ uint32(z)*19349663 ^ uint32(px)*83492791 ^ uint32(pz)*2654435761
+	h ^= h >> 13
+	h *= 0x5bd1e995
+	h ^= h >> 15
+	return float64(h&0xff)/127.5 - 1
+}
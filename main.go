@@ -45,6 +45,7 @@ var (
 var (
 	ic            *imagecache.ImageCache
 	chunkChannel  = make(chan *proxy.ProxiedChunk, 12*12)
+	levelChannel  = make(chan *proxy.ProxiedLevel, 16)
 	mainCtxCancel context.CancelFunc
 )
 
@@ -86,6 +87,7 @@ func main() {
 	bgsEventRouter := startBackgroundRoutine("event router", globalEventRouter.Run)
 	bgsTemplateManager := startBackgroundRoutine("template manager", func(ec <-chan struct{}) { templateManager(ec, cfg.SubTree("web")) })
 	bgsChunkConsumer := startBackgroundRoutine("chunk consumer", chunkConsumer)
+	bgsRenderPool := startBackgroundRoutine("render pool", func(c <-chan struct{}) { tileRenderPool.Run(c, renderWorkers()) })
 	bgsImageCache := startBackgroundRoutine("image cache", func(c <-chan struct{}) {
 		imageCacheCtx, imageCacheCtxCancel := context.WithCancel(context.Background())
 		go func() {
@@ -96,13 +98,18 @@ func main() {
 		ic.WaitExit()
 	})
 
+	bgsTileChanges := startBackgroundRoutine("tile changes", tileChanges.Run)
+	bgsChangedTilesRenderer := startBackgroundRoutine("changed tiles renderer", runChangedTilesRenderer)
+	bgsJobs := startBackgroundRoutine("job manager", jobs.Run)
+	bgsScheduler := startBackgroundRoutine("scheduler", runScheduler)
+
 	bgsProxy := startBackgroundRoutine("proxy", func(c <-chan struct{}) {
 		proxyCtx, proxyCtxCancel := context.WithCancel(context.Background())
 		go func() {
 			<-c
 			proxyCtxCancel()
 		}()
-		proxy.RunProxy(proxyCtx, cfg.SubTree("proxy"), chunkChannel)
+		proxy.RunProxy(proxyCtx, cfg.SubTree("proxy"), chunkChannel, levelChannel)
 	})
 	bgsWeb := startBackgroundRoutine("web server", runWeb)
 
@@ -113,9 +120,14 @@ func main() {
 	log.Println("Waiting for websocket clients to drop...")
 	wsClients.Wait()
 
+	bgsScheduler()
+	bgsJobs()
+	bgsChangedTilesRenderer()
 	bgsProxy()
+	bgsRenderPool()
 	bgsImageCache()
 	bgsChunkConsumer()
+	bgsTileChanges()
 	bgsTemplateManager()
 	bgsEventRouter()
 	bgsMetrics()
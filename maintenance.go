@@ -0,0 +1,199 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+)
+
+func init() {
+	for _, t := range []jobType{{
+		Name:        "cachecleanup",
+		Description: "Remove cached images not written for given time",
+		Run:         runCacheCleanupJob,
+	}, {
+		Name:        "storagestats",
+		Description: "Count chunks and their size in every dimension of every storage for main page",
+		Run:         runStorageStatsJob,
+	}, {
+		Name:        "prunehistory",
+		Description: "Remove old versions of chunks from storages that keep them",
+		Run:         runPruneHistoryJob,
+	}} {
+		registerJobType(t)
+	}
+}
+
+type dimStats struct {
+	ChunkCount uint64
+	ChunkSize  uint64
+	UpdatedAt  time.Time
+}
+
+type dimStatsKey struct {
+	storage, world, dim string
+}
+
+var (
+	// filled by storagestats job, main page counts dimensions without stats itself
+	storageStats     = map[dimStatsKey]dimStats{}
+	storageStatsLock sync.Mutex
+)
+
+func getDimStats(sn string, s chunkStorage.ChunkStorage, wname, dname string) (dimStats, error) {
+	storageStatsLock.Lock()
+	st, ok := storageStats[dimStatsKey{sn, wname, dname}]
+	storageStatsLock.Unlock()
+	if ok {
+		return st, nil
+	}
+	return countDimStats(s, wname, dname)
+}
+
+func countDimStats(s chunkStorage.ChunkStorage, wname, dname string) (dimStats, error) {
+	count, err := s.GetDimensionChunksCount(wname, dname)
+	if err != nil {
+		return dimStats{}, err
+	}
+	size, err := s.GetDimensionChunksSize(wname, dname)
+	if err != nil {
+		return dimStats{}, err
+	}
+	return dimStats{ChunkCount: count, ChunkSize: size, UpdatedAt: time.Now()}, nil
+}
+
+func snapshotStorages() map[string]chunkStorage.Storage {
+	storagesLock.Lock()
+	defer storagesLock.Unlock()
+	ret := make(map[string]chunkStorage.Storage, len(storages))
+	for k, v := range storages {
+		ret[k] = v
+	}
+	return ret
+}
+
+// Calls f for every dimension of online storages
+func forEachStoredDim(ctx context.Context, f func(sn string, s chunkStorage.ChunkStorage, dim chunkStorage.SDim) error) error {
+	for sn, s := range snapshotStorages() {
+		if s.Driver == nil {
+			continue
+		}
+		dims, err := s.Driver.ListDimensions()
+		if err != nil {
+			return fmt.Errorf("listing dimensions of storage %s: %w", sn, err)
+		}
+		for _, d := range dims {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if err := f(sn, s.Driver, d); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+type cacheCleanupJobParams struct {
+	// images not written for this long are removed, defaults to 30 days
+	MaxAgeHours int
+}
+
+func runCacheCleanupJob(ctx context.Context, jc *jobContext) error {
+	var p cacheCleanupJobParams
+	if err := jc.Params(&p); err != nil {
+		return err
+	}
+	if p.MaxAgeHours <= 0 {
+		p.MaxAgeHours = 30 * 24
+	}
+	jc.Progress(0, 0, "Removing cached images")
+	removed, err := ic.RemoveUnmodifiedSince(time.Now().Add(-time.Duration(p.MaxAgeHours) * time.Hour))
+	jc.SetResult(map[string]int{"Removed": removed})
+	jc.Progress(0, 0, fmt.Sprintf("Removed %d cached images", removed))
+	return err
+}
+
+func runStorageStatsJob(ctx context.Context, jc *jobContext) error {
+	counted := 0
+	stats := map[dimStatsKey]dimStats{}
+	err := forEachStoredDim(ctx, func(sn string, s chunkStorage.ChunkStorage, dim chunkStorage.SDim) error {
+		jc.Progress(int64(counted), 0, fmt.Sprintf("Counting %s of %s on %s", dim.Name, dim.World, sn))
+		st, err := countDimStats(s, dim.World, dim.Name)
+		if err != nil {
+			return err
+		}
+		stats[dimStatsKey{sn, dim.World, dim.Name}] = st
+		counted++
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	storageStatsLock.Lock()
+	storageStats = stats
+	storageStatsLock.Unlock()
+	jc.Progress(int64(counted), int64(counted), fmt.Sprintf("Counted %d dimensions", counted))
+	return nil
+}
+
+type pruneHistoryJobParams struct {
+	// all worlds and dimensions when empty
+	World     string
+	Dimension string
+	// versions newer than this are kept, defaults to 30 days
+	KeepHours int
+}
+
+func runPruneHistoryJob(ctx context.Context, jc *jobContext) error {
+	var p pruneHistoryJobParams
+	if err := jc.Params(&p); err != nil {
+		return err
+	}
+	if p.KeepHours <= 0 {
+		p.KeepHours = 30 * 24
+	}
+	before := time.Now().Add(-time.Duration(p.KeepHours) * time.Hour)
+	removed := int64(0)
+	err := forEachStoredDim(ctx, func(sn string, s chunkStorage.ChunkStorage, dim chunkStorage.SDim) error {
+		if !s.GetAbilities().CanPreserveOldChunks ||
+			(p.World != "" && p.World != dim.World) ||
+			(p.Dimension != "" && p.Dimension != dim.Name) {
+			return nil
+		}
+		jc.Progress(removed, 0, fmt.Sprintf("Pruning %s of %s on %s", dim.Name, dim.World, sn))
+		n, err := s.PruneChunksHistory(dim.World, dim.Name, before)
+		if errors.Is(err, chunkStorage.ErrNotImplemented) {
+			return nil
+		}
+		removed += n
+		return err
+	})
+	jc.SetResult(map[string]int64{"Removed": removed})
+	jc.Progress(removed, removed, fmt.Sprintf("Removed %d old chunk versions", removed))
+	return err
+}
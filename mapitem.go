@@ -0,0 +1,165 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"sync"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/data/mapcolors"
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+)
+
+var (
+	mapItemStateColors     []mapcolors.MapColor
+	mapItemStateColorsOnce sync.Once
+)
+
+// Vanilla map colors of known blocks, others get the closest one to their palette color
+func mapItemStateColor(state block.StateID) mapcolors.MapColor {
+	mapItemStateColorsOnce.Do(func() {
+		mapItemStateColors = make([]mapcolors.MapColor, len(block.StateList))
+		for i, b := range block.StateList {
+			c, ok := mapcolors.ForBlock(b.ID())
+			if !ok && i < len(colors) {
+				c = nearestMapColor(colors[i])
+			}
+			mapItemStateColors[i] = c
+		}
+	})
+	return mapItemStateColors[state]
+}
+
+func nearestMapColor(c color.RGBA64) mapcolors.MapColor {
+	if c.A == 0 {
+		return mapcolors.None
+	}
+	r, g, b := int(c.R>>8), int(c.G>>8), int(c.B>>8)
+	ret, best := mapcolors.None, -1
+	for i, m := range mapcolors.Colors {
+		if mapcolors.MapColor(i) == mapcolors.None {
+			continue
+		}
+		dr, dg, db := r-int(m.R), g-int(m.G), b-int(m.B)
+		d := dr*dr + dg*dg + db*db
+		if best < 0 || d < best {
+			ret, best = mapcolors.MapColor(i), d
+		}
+	}
+	return ret
+}
+
+type mapItemColumn struct {
+	color  mapcolors.MapColor
+	height int
+	depth  int // water blocks below the surface including it
+	ok     bool
+}
+
+// Top block that has a map color, like vanilla maps skip glass, torches and such
+func getMapItemColumn(b *chunkBlocks, tops []int, x, z int) (ret mapItemColumn) {
+	if b == nil || len(b.sections) == 0 {
+		return
+	}
+	y := b.maxY - 1
+	if tops != nil && tops[z*16+x] < y {
+		y = tops[z*16+x]
+	}
+	for ; y >= b.minY; y-- {
+		ret.color = mapItemStateColor(b.get(x, y, z))
+		if ret.color != mapcolors.None {
+			break
+		}
+	}
+	if y < b.minY {
+		return
+	}
+	ret.height = y
+	ret.ok = true
+	for ret.color == mapcolors.Water && y >= b.minY && mapItemStateColor(b.get(x, y, z)) == mapcolors.Water {
+		ret.depth++
+		y--
+	}
+	return
+}
+
+// Held map look: vanilla map palette shaded brighter where terrain goes up
+// to the north and darker where it goes down, water is shaded by depth.
+// Parity of coordinates is used for dithering the same way as in game.
+func drawChunkMapItem(d render.ChunkData) *image.RGBA {
+	t := time.Now()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	chunk := d.Get()
+	if chunk == nil {
+		return img
+	}
+	dim, opts := d.GetDimension(), d.GetOptions()
+	b := newChunkBlocks(chunk)
+	tops := chunkColumnTops(chunk, dim, opts)
+	var columns [16 * 16]mapItemColumn
+	for i := range columns {
+		columns[i] = getMapItemColumn(b, tops, i%16, i/16)
+	}
+	var north [16]mapItemColumn
+	if n := d.GetNorth(); n != nil {
+		nb, ntops := newChunkBlocks(n), chunkColumnTops(n, dim, opts)
+		for x := range north {
+			north[x] = getMapItemColumn(nb, ntops, x, 15)
+		}
+	}
+	for i, c := range columns {
+		if !c.ok {
+			continue
+		}
+		x, z := i%16, i/16
+		parity := float64((x + z) & 1)
+		brightness := mapcolors.Normal
+		if c.color == mapcolors.Water {
+			f := float64(c.depth)*0.1 + parity*0.2
+			if f < 0.5 {
+				brightness = mapcolors.High
+			} else if f > 0.9 {
+				brightness = mapcolors.Low
+			}
+		} else {
+			n := north[x]
+			if z > 0 {
+				n = columns[i-16]
+			}
+			nh := c.height
+			if n.ok {
+				nh = n.height
+			}
+			f := float64(c.height-nh)*0.8 + (parity-0.5)*0.4
+			if f > 0.6 {
+				brightness = mapcolors.High
+			} else if f < -0.6 {
+				brightness = mapcolors.Low
+			}
+		}
+		img.Set(x, z, c.color.Shaded(brightness))
+	}
+	appendMetrics(time.Since(t), "mapitem")
+	return img
+}
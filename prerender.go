@@ -0,0 +1,298 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"image"
+	"sort"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// highest zoom out of web map
+const prerenderDefaultMaxScale = 8
+
+// checkpoint is saved after this many tiles or this much time, whichever comes first
+const (
+	prerenderCheckpointTiles    = 256
+	prerenderCheckpointInterval = 30 * time.Second
+)
+
+func init() {
+	registerJobType(jobType{
+		Name:        "prerender",
+		Description: "Render tiles of all stored chunks of a dimension into image cache, skipping tiles newer than their chunks",
+		Run:         runPrerenderJob,
+	})
+}
+
+type prerenderJobParams struct {
+	World     string
+	Dimension string
+	Variants  []string
+	// highest scale to render, defaults to prerenderDefaultMaxScale
+	MaxScale int
+}
+
+type prerenderCheckpoint struct {
+	Variant int
+	Scale   int
+	// next tile of the scale
+	Tile int
+	// tiles of current scale with children rendered in this run, rendered again
+	Stale [][2]int
+	// tiles of next scale with children rendered so far
+	Parents [][2]int
+}
+
+type tilePos struct {
+	X, Z int
+}
+
+func tileSetFromPairs(pairs [][2]int) map[tilePos]bool {
+	ret := map[tilePos]bool{}
+	for _, t := range pairs {
+		ret[tilePos{t[0], t[1]}] = true
+	}
+	return ret
+}
+
+func tileSetToPairs(set map[tilePos]bool) [][2]int {
+	ret := make([][2]int, 0, len(set))
+	for t := range set {
+		ret = append(ret, [2]int{t.X, t.Z})
+	}
+	sort.Slice(ret, func(i, j int) bool {
+		if ret[i][1] != ret[j][1] {
+			return ret[i][1] < ret[j][1]
+		}
+		return ret[i][0] < ret[j][0]
+	})
+	return ret
+}
+
+// Storage level tiles go first and are rendered from chunks, tiles
+// above are put together from cached ones below. Tile is rendered
+// when it's cached image is older than any of it's chunks or when
+// any of it's children was rendered.
+func runPrerenderJob(ctx context.Context, jc *jobContext) error {
+	var p prerenderJobParams
+	if err := jc.Params(&p); err != nil {
+		return err
+	}
+	if p.MaxScale == 0 {
+		p.MaxScale = prerenderDefaultMaxScale
+	}
+	if p.MaxScale < imagecache.StorageLevel {
+		p.MaxScale = imagecache.StorageLevel
+	}
+	if len(p.Variants) == 0 {
+		return errors.New("no variants to render")
+	}
+	for _, v := range p.Variants {
+		rr := renderers.Get(v)
+		if rr == nil {
+			return fmt.Errorf("unknown variant %q", v)
+		}
+		if rr.NeedsQuery || rr.History || rr.RenderTile != nil {
+			return fmt.Errorf("variant %q can not be prerendered", v)
+		}
+	}
+	s, _, err := getJobDimension(p.World, p.Dimension)
+	if err != nil {
+		return err
+	}
+	jc.Progress(0, 0, "Listing chunks")
+	chunks, err := s.ListChunksModDate(p.World, p.Dimension)
+	if err != nil {
+		return err
+	}
+	levels := prerenderLevels(chunks, p.MaxScale)
+	total := 0
+	for _, l := range levels {
+		total += len(l.tiles)
+	}
+	total *= len(p.Variants)
+
+	cp := prerenderCheckpoint{Scale: imagecache.StorageLevel}
+	if err := jc.LoadCheckpoint(&cp); err != nil {
+		return err
+	}
+	done := 0
+	for v := 0; v < cp.Variant; v++ {
+		done += total / len(p.Variants)
+	}
+	for l := imagecache.StorageLevel; l < cp.Scale; l++ {
+		done += len(levels[l-imagecache.StorageLevel].tiles)
+	}
+	done += cp.Tile
+
+	q := tileRenderPool.NewQueue()
+	defer q.Close()
+	stale := tileSetFromPairs(cp.Stale)
+	parents := tileSetFromPairs(cp.Parents)
+	sinceCheckpoint := 0
+	lastCheckpoint := time.Now()
+	checkpoint := func() error {
+		cp.Stale = tileSetToPairs(stale)
+		cp.Parents = tileSetToPairs(parents)
+		sinceCheckpoint = 0
+		lastCheckpoint = time.Now()
+		return jc.Checkpoint(cp)
+	}
+	for cp.Variant < len(p.Variants) {
+		level := levels[cp.Scale-imagecache.StorageLevel]
+		for ; cp.Tile < len(level.tiles); cp.Tile++ {
+			if err := ctx.Err(); err != nil {
+				// keep progress made since last checkpoint
+				if cerr := checkpoint(); cerr != nil {
+					return cerr
+				}
+				return err
+			}
+			if sinceCheckpoint >= prerenderCheckpointTiles || time.Since(lastCheckpoint) >= prerenderCheckpointInterval {
+				if err := checkpoint(); err != nil {
+					return err
+				}
+			}
+			sinceCheckpoint++
+			t := level.tiles[cp.Tile]
+			loc := normalizeImageLocation(primitives.ImageLocation{
+				World:     p.World,
+				Dimension: p.Dimension,
+				Variant:   p.Variants[cp.Variant],
+				S:         cp.Scale,
+				X:         t.X,
+				Z:         t.Z,
+			})
+			jc.Progress(int64(done), int64(total), "Rendering "+loc.String())
+			done++
+			if !stale[t] && !ic.GetCachedImageModTime(loc).Before(level.modTimes[t]) {
+				continue
+			}
+			var img *image.RGBA
+			var rerr error
+			drawnAt := time.Now()
+			if !q.Do(ctx, loc, func() { img, rerr = prerenderTile(loc) }) {
+				if err := ctx.Err(); err != nil {
+					if cerr := checkpoint(); cerr != nil {
+						return cerr
+					}
+					return err
+				}
+				return errors.New("render queue dropped the tile")
+			}
+			if rerr != nil {
+				return rerr
+			}
+			if img != nil {
+				imageCacheSaveLoc(img, loc, drawnAt)
+			}
+			parents[tilePos{t.X >> 1, t.Z >> 1}] = true
+		}
+		cp.Tile = 0
+		stale, parents = parents, map[tilePos]bool{}
+		cp.Scale++
+		if cp.Scale > p.MaxScale {
+			cp.Variant++
+			cp.Scale = imagecache.StorageLevel
+			stale = map[tilePos]bool{}
+		}
+		if err := checkpoint(); err != nil {
+			return err
+		}
+	}
+	jc.Progress(int64(total), int64(total), "")
+	return nil
+}
+
+func prerenderTile(loc primitives.ImageLocation) (*image.RGBA, error) {
+	if loc.S > imagecache.StorageLevel {
+		// children were rendered before and are taken from cache
+		return renderDownscaledTile(loc, false)
+	}
+	return renderTile(loc, true)
+}
+
+type prerenderLevel struct {
+	tiles []tilePos
+	// newest chunk tile shows, including bordering chunks of neighbour tiles
+	modTimes map[tilePos]time.Time
+}
+
+func prerenderLevels(chunks []chunkStorage.ChunkData, maxScale int) []prerenderLevel {
+	base := map[tilePos]time.Time{}
+	for _, c := range chunks {
+		t, ok := c.Data.(time.Time)
+		if !ok {
+			continue
+		}
+		k := tilePos{c.X >> imagecache.StorageLevel, c.Z >> imagecache.StorageLevel}
+		if t.After(base[k]) {
+			base[k] = t
+		}
+	}
+	// renderers looking at neighbours draw edges using chunks of tiles next to them
+	mod := map[tilePos]time.Time{}
+	for _, c := range chunks {
+		t, ok := c.Data.(time.Time)
+		if !ok {
+			continue
+		}
+		for dz := -1; dz <= 1; dz++ {
+			for dx := -1; dx <= 1; dx++ {
+				k := tilePos{(c.X + dx) >> imagecache.StorageLevel, (c.Z + dz) >> imagecache.StorageLevel}
+				if _, ok := base[k]; ok && t.After(mod[k]) {
+					mod[k] = t
+				}
+			}
+		}
+	}
+	ret := []prerenderLevel{}
+	for s := imagecache.StorageLevel; s <= maxScale; s++ {
+		l := prerenderLevel{modTimes: mod}
+		for k := range mod {
+			l.tiles = append(l.tiles, k)
+		}
+		sort.Slice(l.tiles, func(i, j int) bool {
+			if l.tiles[i].Z != l.tiles[j].Z {
+				return l.tiles[i].Z < l.tiles[j].Z
+			}
+			return l.tiles[i].X < l.tiles[j].X
+		})
+		ret = append(ret, l)
+		up := map[tilePos]time.Time{}
+		for k, t := range mod {
+			pk := tilePos{k.X >> 1, k.Z >> 1}
+			if t.After(up[pk]) {
+				up[pk] = t
+			}
+		}
+		mod = up
+	}
+	return ret
+}
@@ -5,8 +5,45 @@ import "fmt"
 type ImageLocation struct {
 	World, Dimension, Variant string
 	S, X, Z                   int
+	// Y cutoff, only blocks at or below it are rendered when HasY is set
+	Y    int
+	HasY bool
+	// unix time history variants compare with, 0 if not set
+	Since int64
+	// normalized block predicates for search variants, empty if not set
+	Query string
+	// hillshade variants parameters, zero if not set
+	Hillshade Hillshade
+	// hours chunk age variants span from hot to cold, 0 if not set
+	AgeHours int
+}
+
+// Light and relief of hillshade renderers
+type Hillshade struct {
+	// degrees clockwise from north light comes from
+	Azimuth int
+	// degrees above horizon
+	Altitude int
+	// vertical scale of terrain
+	Exaggeration float64
 }
 
 func (i ImageLocation) String() string {
-	return fmt.Sprintf("{%s:%s:%s at %ds %dx %dz}", i.World, i.Dimension, i.Variant, i.S, i.X, i.Z)
+	ret := fmt.Sprintf("%s:%s:%s at %ds %dx %dz", i.World, i.Dimension, i.Variant, i.S, i.X, i.Z)
+	if i.HasY {
+		ret += fmt.Sprintf(" below %dy", i.Y)
+	}
+	if i.Since != 0 {
+		ret += fmt.Sprintf(" since %d", i.Since)
+	}
+	if i.Query != "" {
+		ret += fmt.Sprintf(" matching %q", i.Query)
+	}
+	if i.Hillshade != (Hillshade{}) {
+		ret += fmt.Sprintf(" lit from %d at %d x%g", i.Hillshade.Azimuth, i.Hillshade.Altitude, i.Hillshade.Exaggeration)
+	}
+	if i.AgeHours != 0 {
+		ret += fmt.Sprintf(" over %dh", i.AgeHours)
+	}
+	return "{" + ret + "}"
 }
@@ -0,0 +1,98 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// registry codec comes as untyped nbt, some fields (like monster_spawn_light_level)
+// can be either a number or an int provider compound so we can not just unmarshal
+// it into save.DimensionType and have to pick values one by one
+func dimensionTypeFromCodec(de map[string]interface{}) save.DimensionType {
+	return save.DimensionType{
+		Ultrawarm:                   codecBool(de["ultrawarm"]),
+		Natural:                     codecBool(de["natural"]),
+		CoordinatesScale:            codecFloat(de["coordinate_scale"], 1),
+		HasSkylight:                 codecBool(de["has_skylight"]),
+		HasCeiling:                  codecBool(de["has_ceiling"]),
+		AmbientLight:                float32(codecFloat(de["ambient_light"], 0)),
+		FixedTime:                   codecInt(de["fixed_time"], 0),
+		MonsterSpawnLightLevel:      int32(codecInt(de["monster_spawn_light_level"], 7)),
+		MonsterSpawnBlockLightLimit: int32(codecInt(de["monster_spawn_block_light_limit"], 0)),
+		PiglinSafe:                  codecBool(de["piglin_safe"]),
+		BedWorks:                    codecBool(de["bed_works"]),
+		RespawnAnchorWorks:          codecBool(de["respawn_anchor_works"]),
+		HasRaids:                    codecBool(de["has_raids"]),
+		LogicalHeight:               int32(codecInt(de["logical_height"], 0)),
+		MinY:                        int32(codecInt(de["min_y"], 0)),
+		Height:                      int32(codecInt(de["height"], 0)),
+		Infiniburn:                  codecString(de["infiniburn"]),
+		Effects:                     codecString(de["effects"]),
+	}
+}
+
+func codecBool(v interface{}) bool {
+	return codecInt(v, 0) != 0
+}
+
+func codecInt(v interface{}, d int64) int64 {
+	switch vv := v.(type) {
+	case int8:
+		return int64(vv)
+	case int16:
+		return int64(vv)
+	case int32:
+		return int64(vv)
+	case int64:
+		return vv
+	case float32:
+		return int64(vv)
+	case float64:
+		return int64(vv)
+	case map[string]interface{}:
+		// int provider, take upper bound because that is what matters for spawning
+		if val, ok := vv["value"].(map[string]interface{}); ok {
+			return codecInt(val["max_inclusive"], d)
+		}
+		return d
+	default:
+		return d
+	}
+}
+
+func codecFloat(v interface{}, d float64) float64 {
+	switch vv := v.(type) {
+	case float32:
+		return float64(vv)
+	case float64:
+		return vv
+	case int8, int16, int32, int64:
+		return float64(codecInt(vv, 0))
+	default:
+		return d
+	}
+}
+
+func codecString(v interface{}) string {
+	s, _ := v.(string)
+	return s
+}
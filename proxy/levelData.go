@@ -0,0 +1,204 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package proxy
+
+import (
+	"time"
+
+	"github.com/maxsupermanhd/go-vmc/v764/data/packetid"
+	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+// Snapshot of level properties observed during the session,
+// Has* flags tell which groups were actually sent by the server
+type ProxiedLevel struct {
+	Username string
+	Server   string
+
+	HashedSeed int64
+	GameType   int32
+	Hardcore   bool
+
+	HasDifficulty    bool
+	Difficulty       byte
+	DifficultyLocked bool
+
+	HasSpawn               bool
+	SpawnX, SpawnY, SpawnZ int32
+	SpawnAngle             float32
+
+	HasTime bool
+	Time    int64
+	DayTime int64
+
+	HasBorder                    bool
+	BorderCenterX, BorderCenterZ float64
+	BorderSize                   float64
+	BorderSizeLerpTarget         float64
+	BorderSizeLerpTime           int64
+	BorderWarningBlocks          float64
+	BorderWarningTime            float64
+}
+
+// Apply copies known values onto level data, the hashed seed is stored as
+// world seed because the real one is never sent to the client
+func (l *ProxiedLevel) Apply(d *save.LevelData) {
+	d.WorldGenSettings.Seed = l.HashedSeed
+	d.RandomSeed = l.HashedSeed
+	d.GameType = l.GameType
+	d.HardCore = l.Hardcore
+	d.LastPlayed = time.Now().Unix()
+	if l.HasDifficulty {
+		d.Difficulty = l.Difficulty
+		d.DifficultyLocked = l.DifficultyLocked
+	}
+	if l.HasSpawn {
+		d.SpawnX, d.SpawnY, d.SpawnZ = l.SpawnX, l.SpawnY, l.SpawnZ
+		d.SpawnAngle = l.SpawnAngle
+	}
+	if l.HasTime {
+		d.Time = l.Time
+		d.DayTime = l.DayTime
+	}
+	if l.HasBorder {
+		d.BorderCenterX, d.BorderCenterZ = l.BorderCenterX, l.BorderCenterZ
+		d.BorderSize = l.BorderSize
+		d.BorderSizeLerpTarget = l.BorderSizeLerpTarget
+		d.BorderSizeLerpTime = l.BorderSizeLerpTime
+		d.BorderWarningBlocks = l.BorderWarningBlocks
+		d.BorderWarningTime = l.BorderWarningTime
+	}
+}
+
+// returns true if packet changed something worth saving right away,
+// time updates come every second so they are only saved along with other changes
+func (l *ProxiedLevel) update(p pk.Packet) (bool, error) {
+	switch packetid.ClientboundPacketID(p.ID) {
+	case packetid.ClientboundChangeDifficulty:
+		var (
+			difficulty pk.UnsignedByte
+			locked     pk.Boolean
+		)
+		if err := p.Scan(&difficulty, &locked); err != nil {
+			return false, err
+		}
+		l.HasDifficulty = true
+		l.Difficulty = byte(difficulty)
+		l.DifficultyLocked = bool(locked)
+	case packetid.ClientboundGameEvent:
+		var (
+			event pk.UnsignedByte
+			value pk.Float
+		)
+		if err := p.Scan(&event, &value); err != nil {
+			return false, err
+		}
+		if event != 3 { // change game mode
+			return false, nil
+		}
+		l.GameType = int32(value)
+	case packetid.ClientboundSetDefaultSpawnPosition:
+		var (
+			pos   pk.Position
+			angle pk.Float
+		)
+		if err := p.Scan(&pos, &angle); err != nil {
+			return false, err
+		}
+		l.HasSpawn = true
+		l.SpawnX, l.SpawnY, l.SpawnZ = int32(pos.X), int32(pos.Y), int32(pos.Z)
+		l.SpawnAngle = float32(angle)
+	case packetid.ClientboundSetTime:
+		var worldAge, dayTime pk.Long
+		if err := p.Scan(&worldAge, &dayTime); err != nil {
+			return false, err
+		}
+		l.HasTime = true
+		l.Time = int64(worldAge)
+		l.DayTime = int64(dayTime)
+		if l.DayTime < 0 { // negative means daylight cycle is stopped
+			l.DayTime = -l.DayTime
+		}
+		return false, nil
+	case packetid.ClientboundInitializeBorder:
+		var (
+			x, z               pk.Double
+			oldSize, newSize   pk.Double
+			speed              pk.VarLong
+			portalBoundary     pk.VarInt
+			warnBlocks, warnTs pk.VarInt
+		)
+		if err := p.Scan(&x, &z, &oldSize, &newSize, &speed, &portalBoundary, &warnBlocks, &warnTs); err != nil {
+			return false, err
+		}
+		l.HasBorder = true
+		l.BorderCenterX, l.BorderCenterZ = float64(x), float64(z)
+		l.BorderSize = float64(oldSize)
+		l.BorderSizeLerpTarget = float64(newSize)
+		l.BorderSizeLerpTime = int64(speed)
+		l.BorderWarningBlocks = float64(warnBlocks)
+		l.BorderWarningTime = float64(warnTs)
+	case packetid.ClientboundSetBorderCenter:
+		var x, z pk.Double
+		if err := p.Scan(&x, &z); err != nil {
+			return false, err
+		}
+		l.HasBorder = true
+		l.BorderCenterX, l.BorderCenterZ = float64(x), float64(z)
+	case packetid.ClientboundSetBorderSize:
+		var size pk.Double
+		if err := p.Scan(&size); err != nil {
+			return false, err
+		}
+		l.HasBorder = true
+		l.BorderSize = float64(size)
+		l.BorderSizeLerpTarget = float64(size)
+		l.BorderSizeLerpTime = 0
+	case packetid.ClientboundSetBorderLerpSize:
+		var (
+			oldSize, newSize pk.Double
+			speed            pk.VarLong
+		)
+		if err := p.Scan(&oldSize, &newSize, &speed); err != nil {
+			return false, err
+		}
+		l.HasBorder = true
+		l.BorderSize = float64(oldSize)
+		l.BorderSizeLerpTarget = float64(newSize)
+		l.BorderSizeLerpTime = int64(speed)
+	case packetid.ClientboundSetBorderWarningDelay:
+		var warnTs pk.VarInt
+		if err := p.Scan(&warnTs); err != nil {
+			return false, err
+		}
+		l.BorderWarningTime = float64(warnTs)
+	case packetid.ClientboundSetBorderWarningDistance:
+		var warnBlocks pk.VarInt
+		if err := p.Scan(&warnBlocks); err != nil {
+			return false, err
+		}
+		l.BorderWarningBlocks = float64(warnBlocks)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
@@ -60,17 +60,18 @@ func deserializeChunkPacket(p pk.Packet, _ loadedDim) (level.ChunkPos, level.Chu
 		sectionsData pk.ByteArray
 		cc           level.Chunk
 		cpos         level.ChunkPos
+		light        = lightData{
+			SkyLightMask:   make(pk.BitSet, (16*16*16-1)>>6+1),
+			BlockLightMask: make(pk.BitSet, (16*16*16-1)>>6+1),
+			SkyLight:       []pk.ByteArray{},
+			BlockLight:     []pk.ByteArray{},
+		}
 	)
 	err := p.Scan(&cpos, &pk.Tuple{
 		pk.NBT(&heightmaps),
 		&sectionsData,
 		pk.Array(&cc.BlockEntity),
-		&lightData{
-			SkyLightMask:   make(pk.BitSet, (16*16*16-1)>>6+1),
-			BlockLightMask: make(pk.BitSet, (16*16*16-1)>>6+1),
-			SkyLight:       []pk.ByteArray{},
-			BlockLight:     []pk.ByteArray{},
-		},
+		&light,
 	})
 	if err != nil {
 		return cpos, cc, err
@@ -108,6 +109,7 @@ func deserializeChunkPacket(p pk.Packet, _ loadedDim) (level.ChunkPos, level.Chu
 		dl -= n
 		cc.Sections = append(cc.Sections, *ss)
 	}
+	light.apply(cc.Sections)
 	// cc.HeightMaps.MotionBlocking = level.NewBitStorage(int(math.Log2(float64(dim.totalHeight+1))), len(heightmaps.MotionBlocking), heightmaps.MotionBlocking)
 	return cpos, cc, err
 }
@@ -117,6 +119,38 @@ type lightData struct {
 	BlockLightMask pk.BitSet
 	SkyLight       []pk.ByteArray
 	BlockLight     []pk.ByteArray
+	// only filled when reading, sections known to have no light at all
+	EmptySkyLightMask   pk.BitSet
+	EmptyBlockLightMask pk.BitSet
+}
+
+// Light arrays are sent only for sections with mask bit set,
+// first bit is the section below the world and the last one is above it
+func (l *lightData) apply(sections []level.Section) {
+	sky, blk := 0, 0
+	for i := 0; i < len(sections)+2; i++ {
+		inWorld := i > 0 && i <= len(sections)
+		if bitSetHas(l.SkyLightMask, i) && sky < len(l.SkyLight) {
+			if inWorld && len(l.SkyLight[sky]) == 2048 {
+				sections[i-1].SkyLight = l.SkyLight[sky]
+			}
+			sky++
+		} else if inWorld && bitSetHas(l.EmptySkyLightMask, i) {
+			sections[i-1].SkyLight = make([]byte, 2048)
+		}
+		if bitSetHas(l.BlockLightMask, i) && blk < len(l.BlockLight) {
+			if inWorld && len(l.BlockLight[blk]) == 2048 {
+				sections[i-1].BlockLight = l.BlockLight[blk]
+			}
+			blk++
+		} else if inWorld && bitSetHas(l.EmptyBlockLightMask, i) {
+			sections[i-1].BlockLight = make([]byte, 2048)
+		}
+	}
+}
+
+func bitSetHas(set pk.BitSet, i int) bool {
+	return i < set.Len() && set.Get(i)
 }
 
 func bitSetRev(set pk.BitSet) pk.BitSet {
@@ -141,13 +175,12 @@ func (l *lightData) WriteTo(w io.Writer) (int64, error) {
 
 func (l *lightData) ReadFrom(r io.Reader) (int64, error) {
 	var TrustEdges pk.Boolean
-	var RevSkyLightMask, RevBlockLightMask pk.BitSet
 	return pk.Tuple{
 		&TrustEdges, // Trust Edges
 		&l.SkyLightMask,
 		&l.BlockLightMask,
-		&RevSkyLightMask,
-		&RevBlockLightMask,
+		&l.EmptySkyLightMask,
+		&l.EmptyBlockLightMask,
 		pk.Array(&l.SkyLight),
 		pk.Array(&l.BlockLight),
 	}.ReadFrom(r)
@@ -32,6 +32,7 @@ import (
 	"github.com/maxsupermanhd/go-vmc/v764/level/block"
 	"github.com/maxsupermanhd/go-vmc/v764/nbt"
 	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
 	"github.com/maxsupermanhd/go-vmc/v764/server"
 )
 
@@ -61,6 +62,7 @@ type loadedDim struct {
 	minY        int32
 	height      int32
 	totalHeight int32
+	dtype       save.DimensionType
 }
 
 func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQueue, cl clientinfo) {
@@ -75,6 +77,15 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 	c := map[cachePos]cacheChunk{}
 	loadedDims := map[string]loadedDim{}
 	currentDim := ""
+	currentDimType := ""
+	levelInfo := ProxiedLevel{Username: cl.name, Server: cl.dest}
+	levelKnown := false
+	sendLevel := func() {
+		if sp.LevelChannel != nil && levelKnown {
+			l := levelInfo
+			sp.LevelChannel <- &l
+		}
+	}
 	for p := range recv {
 		switch {
 		case p.ID == int32(packetid.ClientboundLevelChunkWithLight):
@@ -82,9 +93,9 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				log.Println("Recieved chunk without dimension")
 				continue
 			}
-			dim, ok := loadedDims[currentDim]
+			dim, ok := loadedDims[currentDimType]
 			if !ok {
-				log.Printf("Got chunk for not loaded dimension?! (%s)", currentDim)
+				log.Printf("Got chunk for not loaded dimension?! (%s of type %s)", currentDim, currentDimType)
 				continue
 			}
 			cpos, cc, err := deserializeChunkPacket(p, dim)
@@ -143,7 +154,7 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 					// 	log.Printf("Failed to find block entity blockstate")
 					// 	continue
 					// }
-					// sectionIndex := (loadedDims[currentDim].minY + dubbe.Y)%16
+					// sectionIndex := (loadedDims[currentDimType].minY + dubbe.Y)%16
 					// if sectionIndex < 0 || len(cc.Sections) < int(sectionIndex)
 					// cc.Sections[sectionIndex]
 					continue
@@ -174,10 +185,11 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				Data:                cc,
 				DimensionLowestY:    dim.minY,
 				DimensionBuildLimit: int(dim.height),
+				DimensionType:       dim.dtype,
 			}
 			// }
 		case p.ID == int32(packetid.ClientboundBlockEntityData):
-			dim, ok := loadedDims[currentDim]
+			dim, ok := loadedDims[currentDimType]
 			if !ok {
 				log.Printf("Recieved block entity data without dimension?!")
 				continue
@@ -230,10 +242,11 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 					Data:                cachedLevel.chunk,
 					DimensionLowestY:    dim.minY,
 					DimensionBuildLimit: int(dim.height),
+					DimensionType:       dim.dtype,
 				}
 			}
 		case p.ID == int32(packetid.ClientboundForgetLevelChunk):
-			dim, ok := loadedDims[currentDim]
+			dim, ok := loadedDims[currentDimType]
 			if !ok {
 				log.Printf("Recieved block entity data without dimension?!")
 				continue
@@ -261,6 +274,7 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				Data:                cachedLevel.chunk,
 				DimensionLowestY:    dim.minY,
 				DimensionBuildLimit: int(dim.height),
+				DimensionType:       dim.dtype,
 			}
 		case p.ID == int32(packetid.ClientboundRespawn):
 			var (
@@ -275,6 +289,8 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 			}
 			log.Printf("respawn to %s (%s)", dimName, dim)
 			currentDim = string(dimName)
+			currentDimType = string(dim)
+			levelInfo.HashedSeed = int64(hashedSeed)
 		case p.ID == int32(packetid.ClientboundLogin):
 			var (
 				eid              pk.Int
@@ -317,6 +333,12 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 				continue
 			}
 			currentDim = string(dimName)
+			currentDimType = string(dim)
+			levelInfo.HashedSeed = int64(hashedSeed)
+			levelInfo.GameType = int32(gamemode)
+			levelInfo.Hardcore = bool(isHardcore)
+			levelKnown = true
+			sendLevel()
 			cod := map[string]interface{}{}
 			err = dimCodec.Unmarshal(&cod)
 			if err != nil {
@@ -377,8 +399,18 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 					minY:        miny,
 					height:      height,
 					totalHeight: height - miny,
+					dtype:       dimensionTypeFromCodec(de),
 				}
 			}
+		default:
+			changed, err := levelInfo.update(p)
+			if err != nil {
+				log.Printf("Failed to parse level packet %v: %s", packetid.ClientboundPacketID(p.ID), err.Error())
+				continue
+			}
+			if changed {
+				sendLevel()
+			}
 		}
 		conn.Push(pk.Marshal(
 			packetid.ClientboundSetActionBarText,
@@ -386,8 +418,9 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 		))
 	}
 	log.Printf("Shutting down packet processor for player [%s], flushing chunks", cl.name)
+	sendLevel()
 	for i, j := range c {
-		dim, ok := loadedDims[currentDim]
+		dim, ok := loadedDims[currentDimType]
 		if !ok {
 			log.Printf("Have no information about dimension [%s]", currentDim)
 			continue
@@ -400,6 +433,7 @@ func (sp SnifferProxy) packetAcceptor(recv chan pk.Packet, conn server.PacketQue
 			Data:                j.chunk,
 			DimensionLowestY:    dim.minY,
 			DimensionBuildLimit: int(dim.height),
+			DimensionType:       dim.dtype,
 		}
 	}
 	log.Printf("Packet processor for player [%s] stopped", cl.name)
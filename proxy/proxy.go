@@ -42,6 +42,7 @@ import (
 	"github.com/maxsupermanhd/go-vmc/v764/net"
 	pk "github.com/maxsupermanhd/go-vmc/v764/net/packet"
 	"github.com/maxsupermanhd/go-vmc/v764/net/queue"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
 	"github.com/maxsupermanhd/go-vmc/v764/server"
 	"github.com/maxsupermanhd/go-vmc/v764/server/auth"
 	"github.com/maxsupermanhd/lac"
@@ -66,6 +67,7 @@ type ProxiedChunk struct {
 	Dimension           string
 	DimensionLowestY    int32
 	DimensionBuildLimit int
+	DimensionType       save.DimensionType
 	Pos                 level.ChunkPos
 	Data                level.Chunk
 }
@@ -82,9 +84,19 @@ var collectPackets = []packetid.ClientboundPacketID{
 	packetid.ClientboundForgetLevelChunk,
 	packetid.ClientboundLogin,
 	packetid.ClientboundRespawn,
+	packetid.ClientboundChangeDifficulty,
+	packetid.ClientboundGameEvent,
+	packetid.ClientboundSetDefaultSpawnPosition,
+	packetid.ClientboundSetTime,
+	packetid.ClientboundInitializeBorder,
+	packetid.ClientboundSetBorderCenter,
+	packetid.ClientboundSetBorderSize,
+	packetid.ClientboundSetBorderLerpSize,
+	packetid.ClientboundSetBorderWarningDelay,
+	packetid.ClientboundSetBorderWarningDistance,
 }
 
-func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk) {
+func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk, levelDump chan *ProxiedLevel) {
 	listenAddr := cfg.GetDSString("localhost:25566", "listen_addr")
 	if listenAddr == "" {
 		log.Println("Proxy disabled")
@@ -127,10 +139,11 @@ func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk
 				r, _ := cfg.GetString("routes", name)
 				return r
 			},
-			CredManager: credentials.NewMicrosoftCredentialsManager(cfg.GetDSString("./cmd/auth/", "credentials_path"), "88650e7e-efee-4857-b9a9-cf580a00ef43"),
-			SaveChannel: dump,
-			Conf:        cfg,
-			Ctx:         ctx,
+			CredManager:  credentials.NewMicrosoftCredentialsManager(cfg.GetDSString("./cmd/auth/", "credentials_path"), "88650e7e-efee-4857-b9a9-cf580a00ef43"),
+			SaveChannel:  dump,
+			LevelChannel: levelDump,
+			Conf:         cfg,
+			Ctx:          ctx,
 		},
 	}
 	listener, err := net.ListenMC(listenAddr)
@@ -172,11 +185,12 @@ func RunProxy(ctx context.Context, cfg *lac.ConfSubtree, dump chan *ProxiedChunk
 }
 
 type SnifferProxy struct {
-	Routing     func(name string) string
-	CredManager *credentials.MicrosoftCredentialsManager
-	SaveChannel chan *ProxiedChunk
-	Conf        *lac.ConfSubtree
-	Ctx         context.Context
+	Routing      func(name string) string
+	CredManager  *credentials.MicrosoftCredentialsManager
+	SaveChannel  chan *ProxiedChunk
+	LevelChannel chan *ProxiedLevel
+	Conf         *lac.ConfSubtree
+	Ctx          context.Context
 }
 
 type clientinfo struct {
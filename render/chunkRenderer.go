@@ -2,7 +2,9 @@ package render
 
 import (
 	"image"
+	"time"
 
+	"github.com/maxsupermanhd/WebChunk/primitives"
 	"github.com/maxsupermanhd/go-vmc/v764/save"
 )
 
@@ -18,16 +20,60 @@ type ChunkData interface {
 	GetSouthWest() *save.Chunk
 	GetWest() *save.Chunk
 	GetWestNorth() *save.Chunk
+	GetCount() int
+	GetOptions() Options
+	// nil if storage does not know when chunk was stored
+	GetModDate() *time.Time
+	// chunk version stored at Options.Since, nil if there was none
+	GetPrevious() *save.Chunk
+}
+
+// Per-request parameters, renderers ignore ones they don't support
+type Options struct {
+	// only blocks at or below Y are rendered when HasY is set
+	Y    int
+	HasY bool
+	// point in time history renderers compare with, zero if not set
+	Since time.Time
+	// block predicates separated by spaces, for search renderers
+	Query string
+	// light and relief for hillshade renderers
+	Hillshade primitives.Hillshade
+	// hours chunk age renderers span from hot to cold
+	AgeHours int
 }
 
 type DataNeeds struct {
 	Dimension          bool
 	NeighborsBordering bool
 	NeighborsCorners   bool
+	// only number of stored chunk versions is provided, no chunk data
+	ChunkCount bool
+	// time latest chunk version was stored at
+	ModDate bool
+	// chunk versions stored at Options.Since
+	History bool
 }
 
 type ChunkRenderer struct {
-	Name   string
-	Render func(ChunkData) *image.RGBA
+	Name        string
+	DisplayName string
+	Description string
+	IsOverlay   bool
+	IsDefault   bool
+	// renderer respects Options.Y
+	SupportsY bool
+	// renderer can not draw without Options.Query
+	NeedsQuery bool
+	// renderer respects Options.Hillshade
+	SupportsHillshade bool
+	// renderer respects Options.AgeHours, it's images get outdated with time
+	SupportsAge bool
+	// magnified tiles get block borders and texture-like noise
+	BlockDetail bool
+	Render      func(ChunkData) *image.RGBA `json:"-"`
+	// draws whole tile of scale s at x z that is size pixels wide
+	// without any chunk data, Render is not used if set
+	RenderTile func(s, x, z, size int) *image.RGBA `json:"-"`
 	DataNeeds
 }
@@ -0,0 +1,200 @@
+package render
+
+import (
+	"errors"
+	"sort"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/chunkStorage"
+	"github.com/maxsupermanhd/go-vmc/v764/save"
+)
+
+type neighbor int
+
+const (
+	north neighbor = iota
+	northEast
+	east
+	eastSouth
+	south
+	southWest
+	west
+	westNorth
+)
+
+var neighborOffsets = [8][2]int{
+	north:     {0, -1},
+	northEast: {1, -1},
+	east:      {1, 0},
+	eastSouth: {1, 1},
+	south:     {0, 1},
+	southWest: {-1, 1},
+	west:      {-1, 0},
+	westNorth: {-1, -1},
+}
+
+// ChunkData provided by GetRegionData, neighbors that were not
+// requested or are not stored are nil
+type RegionChunkData struct {
+	DimensionName string
+	Dimension     *save.DimensionType
+	Chunk         *save.Chunk
+	Neighbors     [8]*save.Chunk
+	Count         int
+	Options       Options
+	ModDate       *time.Time
+	Previous      *save.Chunk
+}
+
+func (d *RegionChunkData) GetDimensionName() string          { return d.DimensionName }
+func (d *RegionChunkData) GetDimension() *save.DimensionType { return d.Dimension }
+func (d *RegionChunkData) Get() *save.Chunk                  { return d.Chunk }
+func (d *RegionChunkData) GetNorth() *save.Chunk             { return d.Neighbors[north] }
+func (d *RegionChunkData) GetNorthEast() *save.Chunk         { return d.Neighbors[northEast] }
+func (d *RegionChunkData) GetEast() *save.Chunk              { return d.Neighbors[east] }
+func (d *RegionChunkData) GetEastSouth() *save.Chunk         { return d.Neighbors[eastSouth] }
+func (d *RegionChunkData) GetSouth() *save.Chunk             { return d.Neighbors[south] }
+func (d *RegionChunkData) GetSouthWest() *save.Chunk         { return d.Neighbors[southWest] }
+func (d *RegionChunkData) GetWest() *save.Chunk              { return d.Neighbors[west] }
+func (d *RegionChunkData) GetWestNorth() *save.Chunk         { return d.Neighbors[westNorth] }
+func (d *RegionChunkData) GetCount() int                     { return d.Count }
+func (d *RegionChunkData) GetOptions() Options               { return d.Options }
+func (d *RegionChunkData) GetModDate() *time.Time            { return d.ModDate }
+func (d *RegionChunkData) GetPrevious() *save.Chunk          { return d.Previous }
+
+var ErrNoSince = errors.New("renderer compares with chunk history but no point in time is set")
+
+type PositionedChunkData struct {
+	X, Z int
+	Data ChunkData
+}
+
+// Dimension type stored in storage or guessed one if storage does not know it
+func GetDimensionType(s chunkStorage.ChunkStorage, wname, dname string) *save.DimensionType {
+	if s != nil {
+		d, err := s.GetDimension(wname, dname)
+		if err == nil && d != nil && d.Data.Height != 0 {
+			return &d.Data
+		}
+	}
+	dt := chunkStorage.GuessDimTypeFromName(dname)
+	return &dt
+}
+
+// Fetches everything renderer needs for chunks in [cx0, cx1) [cz0, cz1)
+func GetRegionData(s chunkStorage.ChunkStorage, wname, dname string, needs DataNeeds, opts Options, cx0, cz0, cx1, cz1 int) ([]PositionedChunkData, error) {
+	ret := []PositionedChunkData{}
+	var dim *save.DimensionType
+	if needs.Dimension {
+		dim = GetDimensionType(s, wname, dname)
+	}
+	if needs.ChunkCount {
+		counts, err := s.GetChunksCountRegion(wname, dname, cx0, cz0, cx1, cz1)
+		if err != nil {
+			return ret, err
+		}
+		for _, v := range counts {
+			c, ok := v.Data.(int)
+			if !ok {
+				continue
+			}
+			ret = append(ret, PositionedChunkData{
+				X: v.X,
+				Z: v.Z,
+				Data: &RegionChunkData{
+					DimensionName: dname,
+					Dimension:     dim,
+					Count:         c,
+					Options:       opts,
+				},
+			})
+		}
+		return ret, nil
+	}
+	if needs.History && opts.Since.IsZero() {
+		return ret, ErrNoSince
+	}
+	pad := 0
+	if needs.NeighborsBordering || needs.NeighborsCorners {
+		pad = 1
+	}
+	type chunkpos struct {
+		X, Z int
+	}
+	bunch := map[chunkpos]*save.Chunk{}
+	unsortedBunch, err := s.GetChunksRegion(wname, dname, cx0-pad, cz0-pad, cx1+pad, cz1+pad)
+	if err != nil {
+		return ret, err
+	}
+	for _, v := range unsortedBunch {
+		c, ok := v.Data.(save.Chunk)
+		if !ok {
+			continue
+		}
+		SortSections(&c)
+		bunch[chunkpos{v.X, v.Z}] = &c
+	}
+	dates := map[chunkpos]*time.Time{}
+	if needs.ModDate {
+		unsortedDates, err := s.GetChunksModDateRegion(wname, dname, cx0, cz0, cx1, cz1)
+		if err != nil {
+			return ret, err
+		}
+		for _, v := range unsortedDates {
+			t, ok := v.Data.(time.Time)
+			if !ok {
+				continue
+			}
+			dates[chunkpos{v.X, v.Z}] = &t
+		}
+	}
+	previous := map[chunkpos]*save.Chunk{}
+	if needs.History {
+		unsortedPrevious, err := s.GetChunksRegionAt(wname, dname, cx0, cz0, cx1, cz1, opts.Since)
+		if err != nil {
+			return ret, err
+		}
+		for _, v := range unsortedPrevious {
+			c, ok := v.Data.(save.Chunk)
+			if !ok {
+				continue
+			}
+			SortSections(&c)
+			previous[chunkpos{v.X, v.Z}] = &c
+		}
+	}
+	for k, v := range bunch {
+		if k.X < cx0 || k.X >= cx1 || k.Z < cz0 || k.Z >= cz1 {
+			continue
+		}
+		d := &RegionChunkData{
+			DimensionName: dname,
+			Dimension:     dim,
+			Chunk:         v,
+			Count:         1,
+			Options:       opts,
+			ModDate:       dates[k],
+			Previous:      previous[k],
+		}
+		for n, o := range neighborOffsets {
+			isCorner := o[0] != 0 && o[1] != 0
+			if (isCorner && needs.NeighborsCorners) || (!isCorner && needs.NeighborsBordering) {
+				d.Neighbors[n] = bunch[chunkpos{k.X + o[0], k.Z + o[1]}]
+			}
+		}
+		ret = append(ret, PositionedChunkData{X: k.X, Z: k.Z, Data: d})
+	}
+	return ret, nil
+}
+
+// SortSections orders chunk sections top to bottom. Chunks given out by
+// GetRegionData are already sorted and shared between chunks painted in
+// parallel (as neighbours), so it only writes when sections are out of order.
+func SortSections(c *save.Chunk) {
+	less := func(i, j int) bool {
+		return int8(c.Sections[i].Y) > int8(c.Sections[j].Y)
+	}
+	if !sort.SliceIsSorted(c.Sections, less) {
+		sort.Slice(c.Sections, less)
+	}
+}
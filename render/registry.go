@@ -0,0 +1,65 @@
+package render
+
+import (
+	"errors"
+	"sort"
+	"sync"
+)
+
+var (
+	ErrAlreadyRegistered = errors.New("renderer already registered")
+	ErrNoRenderFunc      = errors.New("renderer has no render function")
+)
+
+type Registry struct {
+	lock      sync.RWMutex
+	renderers map[string]*ChunkRenderer
+}
+
+func NewRegistry() *Registry {
+	return &Registry{
+		renderers: map[string]*ChunkRenderer{},
+	}
+}
+
+func (r *Registry) Register(cr ChunkRenderer) error {
+	if cr.Render == nil && cr.RenderTile == nil {
+		return ErrNoRenderFunc
+	}
+	r.lock.Lock()
+	defer r.lock.Unlock()
+	if _, ok := r.renderers[cr.Name]; ok {
+		return ErrAlreadyRegistered
+	}
+	r.renderers[cr.Name] = &cr
+	return nil
+}
+
+// returns nil if renderer is not found
+func (r *Registry) Get(name string) *ChunkRenderer {
+	r.lock.RLock()
+	defer r.lock.RUnlock()
+	return r.renderers[name]
+}
+
+// returns first (by name) renderer marked as default or nil
+func (r *Registry) GetDefault() *ChunkRenderer {
+	for _, cr := range r.List() {
+		if cr.IsDefault {
+			return r.Get(cr.Name)
+		}
+	}
+	return nil
+}
+
+// sorted by name
+func (r *Registry) List() []ChunkRenderer {
+	r.lock.RLock()
+	ret := make([]ChunkRenderer, 0, len(r.renderers))
+	for _, cr := range r.renderers {
+		ret = append(ret, *cr)
+	}
+	r.lock.RUnlock()
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret
+}
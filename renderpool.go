@@ -0,0 +1,270 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"runtime"
+	"sync"
+
+	"github.com/maxsupermanhd/WebChunk/primitives"
+)
+
+// jobs over this are dropped oldest first
+const renderQueueMaxLen = 256
+
+var (
+	tileRenderPool = newRenderPool()
+	httpTileQueues = &httpQueues{queues: map[string]*httpQueue{}}
+)
+
+func renderWorkers() int {
+	return cfg.GetDSInt(runtime.NumCPU(), "render_workers")
+}
+
+// Bounded pool of tile rendering workers. Every client has it's own queue,
+// workers take jobs from queues in turns and newest job first so tiles
+// client panned away from wait behind ones it looks at now.
+type renderPool struct {
+	lock    sync.Mutex
+	cond    *sync.Cond
+	queues  []*renderQueue
+	next    int
+	stopped bool
+}
+
+type renderQueue struct {
+	pool *renderPool
+	// oldest first
+	jobs []*renderJob
+}
+
+type renderJob struct {
+	loc primitives.ImageLocation
+	run func()
+	// closed if job is removed without running
+	dropped chan struct{}
+}
+
+func newRenderPool() *renderPool {
+	p := &renderPool{}
+	p.cond = sync.NewCond(&p.lock)
+	return p
+}
+
+// starts workers and waits for exit, pending jobs are dropped
+// and ones already running are waited for
+func (p *renderPool) Run(exitchan <-chan struct{}, workers int) {
+	if workers < 1 {
+		workers = 1
+	}
+	var wg sync.WaitGroup
+	wg.Add(workers)
+	for i := 0; i < workers; i++ {
+		go func() {
+			p.worker()
+			wg.Done()
+		}()
+	}
+	<-exitchan
+	p.lock.Lock()
+	p.stopped = true
+	for _, q := range p.queues {
+		q.dropLocked(func(*renderJob) bool { return true })
+	}
+	p.cond.Broadcast()
+	p.lock.Unlock()
+	wg.Wait()
+}
+
+func (p *renderPool) worker() {
+	for {
+		p.lock.Lock()
+		j := p.takeLocked()
+		for j == nil && !p.stopped {
+			p.cond.Wait()
+			j = p.takeLocked()
+		}
+		p.lock.Unlock()
+		if j == nil {
+			return
+		}
+		j.run()
+	}
+}
+
+func (p *renderPool) takeLocked() *renderJob {
+	for i := range p.queues {
+		qi := (p.next + i) % len(p.queues)
+		q := p.queues[qi]
+		if len(q.jobs) == 0 {
+			continue
+		}
+		p.next = (qi + 1) % len(p.queues)
+		j := q.jobs[len(q.jobs)-1]
+		q.jobs = q.jobs[:len(q.jobs)-1]
+		return j
+	}
+	return nil
+}
+
+func (p *renderPool) NewQueue() *renderQueue {
+	q := &renderQueue{pool: p}
+	p.lock.Lock()
+	p.queues = append(p.queues, q)
+	p.lock.Unlock()
+	return q
+}
+
+func (q *renderQueue) push(loc primitives.ImageLocation, f func()) *renderJob {
+	j := &renderJob{
+		loc:     loc,
+		run:     f,
+		dropped: make(chan struct{}),
+	}
+	q.pool.lock.Lock()
+	defer q.pool.lock.Unlock()
+	if q.pool.stopped {
+		close(j.dropped)
+		return j
+	}
+	q.jobs = append(q.jobs, j)
+	if len(q.jobs) > renderQueueMaxLen {
+		close(q.jobs[0].dropped)
+		q.jobs = q.jobs[1:]
+	}
+	q.pool.cond.Signal()
+	return j
+}
+
+func (q *renderQueue) dropLocked(match func(*renderJob) bool) {
+	kept := q.jobs[:0]
+	for _, j := range q.jobs {
+		if match(j) {
+			close(j.dropped)
+		} else {
+			kept = append(kept, j)
+		}
+	}
+	for i := len(kept); i < len(q.jobs); i++ {
+		q.jobs[i] = nil
+	}
+	q.jobs = kept
+}
+
+// queues f to be run by a worker
+func (q *renderQueue) Go(loc primitives.ImageLocation, f func()) {
+	q.push(loc, f)
+}
+
+// Runs f on a worker and waits for it to finish. Returns false without
+// running f if ctx is done or the job was dropped before it started.
+func (q *renderQueue) Do(ctx context.Context, loc primitives.ImageLocation, f func()) bool {
+	done := make(chan struct{})
+	j := q.push(loc, func() {
+		defer close(done)
+		f()
+	})
+	select {
+	case <-done:
+		return true
+	case <-j.dropped:
+		return false
+	case <-ctx.Done():
+	}
+	q.pool.lock.Lock()
+	q.dropLocked(func(o *renderJob) bool { return o == j })
+	q.pool.lock.Unlock()
+	select {
+	case <-done:
+		return true
+	case <-j.dropped:
+		return false
+	}
+}
+
+// drops pending jobs for loc
+func (q *renderQueue) Cancel(loc primitives.ImageLocation) {
+	q.pool.lock.Lock()
+	q.dropLocked(func(j *renderJob) bool { return j.loc == loc })
+	q.pool.lock.Unlock()
+}
+
+// drops pending jobs and removes queue from the pool
+func (q *renderQueue) Close() {
+	p := q.pool
+	p.lock.Lock()
+	defer p.lock.Unlock()
+	q.dropLocked(func(*renderJob) bool { return true })
+	for i, o := range p.queues {
+		if o == q {
+			p.queues = append(p.queues[:i], p.queues[i+1:]...)
+			break
+		}
+	}
+}
+
+// Http tile requests of one client share a queue so it's newest
+// requests go first without others waiting behind it. Clients are told
+// apart by address (all look the same behind reverse proxy), queue
+// is removed when client has no requests in flight.
+type httpQueues struct {
+	lock   sync.Mutex
+	queues map[string]*httpQueue
+}
+
+type httpQueue struct {
+	q     *renderQueue
+	users int
+}
+
+func httpClientKey(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+// runs f on a worker in queue of request's client, same as renderQueue.Do
+func (h *httpQueues) Do(r *http.Request, loc primitives.ImageLocation, f func()) bool {
+	key := httpClientKey(r)
+	h.lock.Lock()
+	hq, ok := h.queues[key]
+	if !ok {
+		hq = &httpQueue{q: tileRenderPool.NewQueue()}
+		h.queues[key] = hq
+	}
+	hq.users++
+	h.lock.Unlock()
+	defer func() {
+		h.lock.Lock()
+		hq.users--
+		if hq.users == 0 {
+			delete(h.queues, key)
+			hq.q.Close()
+		}
+		h.lock.Unlock()
+	}()
+	return hq.q.Do(r.Context(), loc, f)
+}
@@ -22,116 +22,195 @@ package main
 
 import (
 	"bytes"
-	"context"
 	_ "embed"
 	"errors"
 	"image"
-	"image/draw"
 	"image/jpeg"
 	"image/png"
 	"log"
 	"net/http"
-	"runtime/debug"
-	"sort"
 	"strconv"
-	"strings"
 	_ "sync"
+	"time"
 
 	"github.com/gorilla/mux"
-	"github.com/maxsupermanhd/WebChunk/chunkStorage"
-	"github.com/maxsupermanhd/go-vmc/v764/save"
-	"github.com/nfnt/resize"
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/WebChunk/render"
 )
 
-type chunkDataProviderFunc = func(wname, dname string, cx0, cz0, cx1, cz1 int) ([]chunkStorage.ChunkData, error)
-type chunkPainterFunc = func(interface{}) *image.RGBA
-type ttypeProviderFunc = func(chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc)
+var renderers = render.NewRegistry()
 
-type ttype struct {
-	Name        string
-	DisplayName string
-	IsOverlay   bool
-	IsDefault   bool
-}
-
-var ttypes = map[ttype]ttypeProviderFunc{
-	{"terrain", "Terrain", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunk(&c)
-		}
-	},
-	{"shadedterrain", "Shaded terrain", false, true}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
-			return drawShadedTerrain(i.(ContextedChunkData))
-		}
-	},
-	{"counttiles", "Chunk count", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksCountRegion, func(i interface{}) *image.RGBA {
-			return drawNumberOfChunks(int(i.(int)))
-		}
-	},
-	{"counttilesheat", "Chunk count heatmap", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksCountRegion, func(i interface{}) *image.RGBA {
-			return drawHeatOfChunks(int(i.(int)))
-		}
-	},
-	{"heightmap", "Heightmap", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunkHeightmap(&c)
-		}
-	},
-	{"xray", "Xray", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunkXray(&c)
-		}
-	},
-	{"biomes", "Biomes", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunkBiomes(&c)
-		}
-	},
-	{"portalsheat", "Portals heatmap", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunkPortalBlocksHeatmap(&c)
-		}
-	},
-	{"chestheat", "Chest heatmap", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunkChestBlocksHeatmap(&c)
-		}
-	},
-	{"lavaage", "Lava age", false, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunkLavaAge(&c, 255)
-		}
-	},
-	{"lavaageoverlay", "Lava age (overlay)", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return s.GetChunksRegion, func(i interface{}) *image.RGBA {
-			c := i.(save.Chunk)
-			return drawChunkLavaAge(&c, 128)
-		}
-	},
-	{"shading", "Shading", true, false}: func(s chunkStorage.ChunkStorage) (chunkDataProviderFunc, chunkPainterFunc) {
-		return getChunksRegionWithContextFN(s), func(i interface{}) *image.RGBA {
-			return drawChunkShading(i.(ContextedChunkData))
+func init() {
+	for _, r := range []render.ChunkRenderer{{
+		Name:        "terrain",
+		DisplayName: "Terrain",
+		Description: "Top non-air block colors",
+		SupportsY:   true,
+		BlockDetail: true,
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunk(d.Get(), chunkColumnTops(d.Get(), d.GetDimension(), d.GetOptions()), renderBiomeTinter(d))
+		},
+		DataNeeds: render.DataNeeds{Dimension: true, NeighborsBordering: true, NeighborsCorners: true},
+	}, {
+		Name:              "shadedterrain",
+		DisplayName:       "Shaded terrain",
+		Description:       "Terrain with shadows from neighboring blocks",
+		IsDefault:         true,
+		SupportsY:         true,
+		SupportsHillshade: true,
+		BlockDetail:       true,
+		Render:            drawShadedTerrain,
+		DataNeeds:         render.DataNeeds{Dimension: true, NeighborsBordering: true, NeighborsCorners: true},
+	}, {
+		Name:        "counttiles",
+		DisplayName: "Chunk count",
+		Description: "Number of stored versions of each chunk",
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawNumberOfChunks(d.GetCount())
+		},
+		DataNeeds: render.DataNeeds{ChunkCount: true},
+	}, {
+		Name:        "counttilesheat",
+		DisplayName: "Chunk count heatmap",
+		Description: "Heatmap of number of stored versions of each chunk",
+		IsOverlay:   true,
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawHeatOfChunks(d.GetCount())
+		},
+		DataNeeds: render.DataNeeds{ChunkCount: true},
+	}, {
+		Name:        "heightmap",
+		DisplayName: "Heightmap",
+		Description: "Height of top non-air block within dimension build range",
+		SupportsY:   true,
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunkHeightmap(d.Get(), *d.GetDimension(), chunkColumnTops(d.Get(), d.GetDimension(), d.GetOptions()))
+		},
+		DataNeeds: render.DataNeeds{Dimension: true},
+	}, {
+		Name:        "xray",
+		DisplayName: "Xray",
+		Description: "Ores and other valuable blocks seen through terrain",
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunkXray(d.Get())
+		},
+	}, {
+		Name:        "biomes",
+		DisplayName: "Biomes",
+		Description: "Biome colors",
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunkBiomes(d.Get())
+		},
+	}, {
+		Name:        "portalsheat",
+		DisplayName: "Portals heatmap",
+		Description: "Number of nether portal blocks in a chunk",
+		IsOverlay:   true,
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunkPortalBlocksHeatmap(d.Get())
+		},
+	}, {
+		Name:        "chestheat",
+		DisplayName: "Chest heatmap",
+		Description: "Number of chests in a chunk",
+		IsOverlay:   true,
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunkChestBlocksHeatmap(d.Get())
+		},
+	}, {
+		Name:        "spawnable",
+		DisplayName: "Mob spawning",
+		Description: "Surfaces dark enough for hostile mobs to spawn at night",
+		IsOverlay:   true,
+		SupportsY:   true,
+		Render:      drawChunkSpawnable,
+		DataNeeds:   render.DataNeeds{Dimension: true},
+	}, {
+		Name:        "lavaage",
+		DisplayName: "Lava age",
+		Description: "Lava flow levels",
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunkLavaAge(d.Get(), 255)
+		},
+	}, {
+		Name:        "lavaageoverlay",
+		DisplayName: "Lava age (overlay)",
+		Description: "Semi-transparent lava flow levels",
+		IsOverlay:   true,
+		Render: func(d render.ChunkData) *image.RGBA {
+			return drawChunkLavaAge(d.Get(), 128)
+		},
+	}, {
+		Name:        "isometric",
+		DisplayName: "Isometric",
+		Description: "3D view from south-east with shaded block faces",
+		Render:      drawChunkIsometric,
+		DataNeeds:   render.DataNeeds{Dimension: true, NeighborsBordering: true, NeighborsCorners: true},
+	}, {
+		Name:        "contours",
+		DisplayName: "Contours",
+		Description: "Iso-height lines with labeled index lines",
+		IsOverlay:   true,
+		SupportsY:   true,
+		Render:      drawChunkContours,
+		DataNeeds:   render.DataNeeds{Dimension: true, NeighborsBordering: true},
+	}, {
+		Name:        "mapitem",
+		DisplayName: "Map item",
+		Description: "Colors and north slope shading of in-game maps",
+		SupportsY:   true,
+		BlockDetail: true,
+		Render:      drawChunkMapItem,
+		DataNeeds:   render.DataNeeds{Dimension: true, NeighborsBordering: true},
+	}, {
+		Name:              "shading",
+		DisplayName:       "Shading",
+		Description:       "Hillshade lit from configurable sun direction",
+		IsOverlay:         true,
+		SupportsY:         true,
+		SupportsHillshade: true,
+		Render:            drawChunkShading,
+		DataNeeds:         render.DataNeeds{Dimension: true, NeighborsBordering: true, NeighborsCorners: true},
+	}, {
+		Name:        "ageheat",
+		DisplayName: "Chunk age",
+		Description: "When chunks were last stored, recent ones are red",
+		IsOverlay:   true,
+		SupportsAge: true,
+		Render:      drawChunkAgeHeat,
+		DataNeeds:   render.DataNeeds{ModDate: true},
+	}, {
+		Name:        "inhabitedheat",
+		DisplayName: "Inhabited time",
+		Description: "How long players spent in chunks",
+		IsOverlay:   true,
+		Render:      drawChunkInhabitedHeat,
+	}, {
+		Name:        "diff",
+		DisplayName: "Changes",
+		Description: "Blocks placed, removed or replaced since given time",
+		IsOverlay:   true,
+		Render:      drawChunkDiff,
+		DataNeeds:   render.DataNeeds{History: true},
+	}, {
+		Name:        "search",
+		DisplayName: "Block search",
+		Description: "Chunks and columns containing blocks matching given predicates",
+		IsOverlay:   true,
+		NeedsQuery:  true,
+		Render:      drawChunkBlockSearch,
+	}, {
+		Name:        "grid",
+		DisplayName: "Grid",
+		Description: "Chunk and region borders with coordinates",
+		IsOverlay:   true,
+		RenderTile:  drawGridTile,
+	}} {
+		if err := renderers.Register(r); err != nil {
+			log.Fatalf("Failed to register renderer %q: %s", r.Name, err.Error())
 		}
-	},
-}
-
-func listttypes() []ttype {
-	keys := make([]ttype, 0, len(ttypes))
-	for t := range ttypes {
-		keys = append(keys, t)
 	}
-	sort.Slice(keys, func(i, j int) bool { return strings.Compare(keys[i].Name, keys[j].Name) > 0 })
-	return keys
 }
 
 func tileRouterHandler(w http.ResponseWriter, r *http.Request) {
@@ -141,9 +220,56 @@ func tileRouterHandler(w http.ResponseWriter, r *http.Request) {
 	if err != nil {
 		return
 	}
-	if !r.URL.Query().Has("cached") || r.URL.Query().Get("cached") == "true" {
-		img := imageCacheGetBlocking(wname, dname, datatype, cs, cx, cz)
-		if img != nil {
+	y, hasY, err := tileCutoffParam(w, r)
+	if err != nil {
+		return
+	}
+	since, err := tileSinceParam(w, r)
+	if err != nil {
+		return
+	}
+	query, err := tileQueryParam(w, r)
+	if err != nil {
+		return
+	}
+	hillshade, err := tileHillshadeParam(w, r)
+	if err != nil {
+		return
+	}
+	ageHours, err := tileAgeParam(w, r)
+	if err != nil {
+		return
+	}
+	loc := normalizeImageLocation(primitives.ImageLocation{
+		World:     wname,
+		Dimension: dname,
+		Variant:   datatype,
+		S:         cs,
+		X:         cx,
+		Z:         cz,
+		Y:         y,
+		HasY:      hasY,
+		Since:     since,
+		Query:     query,
+		Hillshade: hillshade,
+		AgeHours:  ageHours,
+	})
+	if _, err := parseCompositeVariant(datatype); err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Bad variant: "+err.Error())
+		return
+	}
+	if rr := renderers.Get(datatype); rr != nil && rr.History && loc.Since == 0 {
+		plainmsg(w, r, plainmsgColorRed, "Variant needs since parameter")
+		return
+	}
+	if rr := renderers.Get(datatype); rr != nil && rr.NeedsQuery && loc.Query == "" {
+		plainmsg(w, r, plainmsgColorRed, "Variant needs blocks parameter")
+		return
+	}
+	ignoreCache := r.URL.Query().Has("cached") && r.URL.Query().Get("cached") != "true"
+	// magnified tiles are cut out of cached chunk images by imageGetSync
+	if !ignoreCache && loc.S >= 0 {
+		if img, _ := imageCacheGetFreshLoc(loc); img != nil {
 			b := bytes.NewBuffer([]byte{})
 			err := png.Encode(b, img)
 			if err != nil {
@@ -159,91 +285,32 @@ func tileRouterHandler(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 	}
-	_, s, err := chunkStorage.GetWorldStorage(storages, wname)
+	// rendering goes through the pool so concurrent renders are bounded
+	// and newest requests of every client are served first
+	if !httpTileQueues.Do(r, loc, func() { renderTileResponse(w, r, loc, fname, ignoreCache) }) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}
+}
+
+// Renders tile that was not taken from cache. Every render goes through
+// imageGetSync so concurrent requests of the same tile draw it once, and
+// it is not tied to the request so tile is finished and cached even if
+// client goes away.
+func renderTileResponse(w http.ResponseWriter, r *http.Request, loc primitives.ImageLocation, fname string, ignoreCache bool) {
+	img, err := imageGetSync(loc, ignoreCache)
 	if err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Error rendering tile: "+err.Error())
 		return
 	}
-	var ff ttypeProviderFunc
-	ffound := false
-	for tt := range ttypes {
-		if tt.Name == datatype {
-			ff = ttypes[tt]
-			ffound = true
-		}
-	}
-	if !ffound {
-		w.WriteHeader(http.StatusBadRequest)
+	if r.Context().Err() != nil {
 		return
 	}
-	g, p := ff(s)
-	img := scaleImageryHandler(w, r, g, p)
 	if img == nil {
+		w.WriteHeader(http.StatusNoContent)
 		return
 	}
-	if r.Header.Get("Cache-Control") != "no-store" {
-		imageCacheSave(img, wname, dname, datatype, cs, cx, cz)
-	}
 	w.WriteHeader(http.StatusOK)
 	writeImage(w, fname, img)
-	imageCacheSave(img, wname, dname, datatype, cs, cx, cz)
-}
-
-func scaleImageryHandler(w http.ResponseWriter, r *http.Request, getter chunkDataProviderFunc, painter chunkPainterFunc) *image.RGBA {
-	wname, dname, _, cx, cz, cs, err := tilingParams(w, r)
-	log.Println("Requested tile", wname, dname, cx, cz, cs)
-	if err != nil {
-		return nil
-	}
-	scale := 1
-	if cs > 0 {
-		scale = int(2 << (cs - 1))
-	}
-	imagesize := scale * 16
-	if imagesize > 512 {
-		imagesize = 512
-	}
-	img := image.NewRGBA(image.Rect(0, 0, int(imagesize), int(imagesize)))
-	imagescale := int(imagesize / scale)
-	offsetx := cx * scale
-	offsety := cz * scale
-	cc, err := getter(wname, dname, cx*scale, cz*scale, cx*scale+scale, cz*scale+scale)
-	if err != nil {
-		plainmsg(w, r, plainmsgColorRed, "Error getting chunk data: "+err.Error())
-		log.Println("Error getting chunk data: ", err)
-		return nil
-	}
-	if len(cc) == 0 {
-		w.WriteHeader(http.StatusNoContent)
-		return nil
-	}
-	for _, c := range cc {
-		if errors.Is(r.Context().Err(), context.Canceled) {
-			return img
-		}
-		placex := int(c.X - offsetx)
-		placey := int(c.Z - offsety)
-		var chunk *image.RGBA
-		chunk = func(d interface{}) *image.RGBA {
-			defer func() {
-				if err := recover(); err != nil {
-					log.Println(cx, cz, err)
-					debug.PrintStack()
-				}
-				chunk = nil
-			}()
-			var ret *image.RGBA
-			ret = nil
-			ret = painter(d)
-			return ret
-		}(c.Data)
-		if chunk == nil {
-			continue
-		}
-		tile := resize.Resize(uint(imagescale), uint(imagescale), chunk, resize.NearestNeighbor)
-		draw.Draw(img, image.Rect(placex*int(imagescale), placey*int(imagescale), placex*int(imagescale)+imagescale, placey*int(imagescale)+imagescale),
-			tile, image.Pt(0, 0), draw.Over)
-	}
-	return img
 }
 
 func tilingParams(w http.ResponseWriter, r *http.Request) (wname, dname, fname string, cx, cz, cs int, err error) {
@@ -276,9 +343,107 @@ func tilingParams(w http.ResponseWriter, r *http.Request) (wname, dname, fname s
 		return
 	}
 	cs = int(csb)
+	if cs < imagecache.MinScale {
+		err = errors.New("scale is too small")
+		plainmsg(w, r, plainmsgColorRed, "Bad s id: "+err.Error())
+		return
+	}
+	return
+}
+
+// optional y= query parameter, renderers supporting it draw only blocks at or below it
+func tileCutoffParam(w http.ResponseWriter, r *http.Request) (y int, hasY bool, err error) {
+	if !r.URL.Query().Has("y") {
+		return
+	}
+	yb, err := strconv.ParseInt(r.URL.Query().Get("y"), 10, 32)
+	if err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Bad y: "+err.Error())
+		return
+	}
+	return int(yb), true, nil
+}
+
+// optional since= query parameter, unix seconds or RFC 3339 time
+// history renderers compare chunks with
+func tileSinceParam(w http.ResponseWriter, r *http.Request) (since int64, err error) {
+	if !r.URL.Query().Has("since") {
+		return
+	}
+	since, err = parseSince(r.URL.Query().Get("since"))
+	if err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Bad since: "+err.Error())
+	}
+	return
+}
+
+// optional blocks= query parameter, block predicates separated by spaces
+// search renderers look for
+func tileQueryParam(w http.ResponseWriter, r *http.Request) (query string, err error) {
+	if !r.URL.Query().Has("blocks") {
+		return
+	}
+	query, err = normalizeBlockQuery(r.URL.Query().Get("blocks"))
+	if err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Bad blocks: "+err.Error())
+	}
+	return
+}
+
+// optional azimuth=, altitude= and exaggeration= query parameters
+// hillshade renderers use, missing ones are taken from config
+func tileHillshadeParam(w http.ResponseWriter, r *http.Request) (h primitives.Hillshade, err error) {
+	q := r.URL.Query()
+	if !q.Has("azimuth") && !q.Has("altitude") && !q.Has("exaggeration") {
+		return
+	}
+	h = defaultHillshade()
+	for _, p := range []struct {
+		name string
+		val  *int
+	}{{"azimuth", &h.Azimuth}, {"altitude", &h.Altitude}} {
+		if !q.Has(p.name) {
+			continue
+		}
+		v, err := strconv.Atoi(q.Get(p.name))
+		if err != nil {
+			plainmsg(w, r, plainmsgColorRed, "Bad "+p.name+": "+err.Error())
+			return h, err
+		}
+		*p.val = v
+	}
+	if q.Has("exaggeration") {
+		h.Exaggeration, err = strconv.ParseFloat(q.Get("exaggeration"), 64)
+		if err != nil {
+			plainmsg(w, r, plainmsgColorRed, "Bad exaggeration: "+err.Error())
+		}
+	}
 	return
 }
 
+// optional hours= query parameter, chunk age renderers span it from hot to cold
+func tileAgeParam(w http.ResponseWriter, r *http.Request) (hours int, err error) {
+	if !r.URL.Query().Has("hours") {
+		return
+	}
+	hours, err = strconv.Atoi(r.URL.Query().Get("hours"))
+	if err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Bad hours: "+err.Error())
+	}
+	return
+}
+
+func parseSince(v string) (int64, error) {
+	if since, err := strconv.ParseInt(v, 10, 64); err == nil {
+		return since, nil
+	}
+	t, err := time.Parse(time.RFC3339, v)
+	if err != nil {
+		return 0, err
+	}
+	return t.Unix(), nil
+}
+
 func writeImage(w http.ResponseWriter, format string, img *image.RGBA) {
 	switch format {
 	case "jpeg":
@@ -0,0 +1,329 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/maxsupermanhd/lac"
+)
+
+// Parsed cron expression: minute, hour, day of month, month and day of week,
+// each field is a list of values, ranges and steps (*/15, 1-5, 0,30)
+type cronSchedule struct {
+	minute, hour, dom, month, dow uint64
+	// when both days are restricted matching either one is enough, same as in cron
+	domAny, dowAny bool
+}
+
+var cronShortcuts = map[string]string{
+	"@hourly":  "0 * * * *",
+	"@daily":   "0 0 * * *",
+	"@weekly":  "0 0 * * 0",
+	"@monthly": "0 0 1 * *",
+	"@yearly":  "0 0 1 1 *",
+}
+
+func parseCron(s string) (*cronSchedule, error) {
+	if e, ok := cronShortcuts[strings.TrimSpace(s)]; ok {
+		s = e
+	}
+	f := strings.Fields(s)
+	if len(f) != 5 {
+		return nil, errors.New("cron expression must have 5 fields")
+	}
+	var c cronSchedule
+	var err error
+	if c.minute, err = parseCronField(f[0], 0, 59); err != nil {
+		return nil, fmt.Errorf("minute: %w", err)
+	}
+	if c.hour, err = parseCronField(f[1], 0, 23); err != nil {
+		return nil, fmt.Errorf("hour: %w", err)
+	}
+	if c.dom, err = parseCronField(f[2], 1, 31); err != nil {
+		return nil, fmt.Errorf("day of month: %w", err)
+	}
+	if c.month, err = parseCronField(f[3], 1, 12); err != nil {
+		return nil, fmt.Errorf("month: %w", err)
+	}
+	if c.dow, err = parseCronField(f[4], 0, 7); err != nil {
+		return nil, fmt.Errorf("day of week: %w", err)
+	}
+	// both 0 and 7 are sunday
+	if c.dow&(1<<7) != 0 {
+		c.dow |= 1
+	}
+	c.domAny = f[2] == "*"
+	c.dowAny = f[4] == "*"
+	return &c, nil
+}
+
+func parseCronField(s string, min, max int) (uint64, error) {
+	var ret uint64
+	for _, p := range strings.Split(s, ",") {
+		step := 1
+		if i := strings.IndexByte(p, '/'); i >= 0 {
+			var err error
+			step, err = strconv.Atoi(p[i+1:])
+			if err != nil || step <= 0 {
+				return 0, fmt.Errorf("bad step %q", p)
+			}
+			p = p[:i]
+		}
+		from, to := min, max
+		if p != "*" {
+			r := strings.SplitN(p, "-", 2)
+			var err error
+			from, err = strconv.Atoi(r[0])
+			if err != nil {
+				return 0, fmt.Errorf("bad value %q", p)
+			}
+			to = from
+			if len(r) == 2 {
+				to, err = strconv.Atoi(r[1])
+				if err != nil {
+					return 0, fmt.Errorf("bad value %q", p)
+				}
+			} else if step != 1 {
+				to = max
+			}
+		}
+		if from < min || to > max || from > to {
+			return 0, fmt.Errorf("%q out of range %d-%d", p, min, max)
+		}
+		for v := from; v <= to; v += step {
+			ret |= 1 << v
+		}
+	}
+	return ret, nil
+}
+
+func (c *cronSchedule) Matches(t time.Time) bool {
+	if c.minute&(1<<t.Minute()) == 0 || c.hour&(1<<t.Hour()) == 0 || c.month&(1<<int(t.Month())) == 0 {
+		return false
+	}
+	return c.dayMatches(t)
+}
+
+func (c *cronSchedule) dayMatches(t time.Time) bool {
+	dom := c.dom&(1<<t.Day()) != 0
+	dow := c.dow&(1<<int(t.Weekday())) != 0
+	switch {
+	case c.domAny && c.dowAny:
+		return true
+	case c.domAny:
+		return dow
+	case c.dowAny:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// first matching minute after given time, zero if there is none within 5 years
+func (c *cronSchedule) Next(after time.Time) time.Time {
+	t := after.Truncate(time.Minute).Add(time.Minute)
+	end := t.AddDate(5, 0, 0)
+	for t.Before(end) {
+		if c.month&(1<<int(t.Month())) == 0 || !c.dayMatches(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day()+1, 0, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.hour&(1<<t.Hour()) == 0 {
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if c.minute&(1<<t.Minute()) == 0 {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
+
+// Entry of schedule config object, creates job of given type when cron matches
+type scheduleEntry struct {
+	Cron     string
+	Type     string
+	Params   map[string]any
+	Disabled bool
+}
+
+func loadSchedule() (map[string]scheduleEntry, error) {
+	ret := map[string]scheduleEntry{}
+	err := cfg.GetToStruct(&ret, "schedule")
+	if errors.Is(err, lac.ErrNoKey) {
+		err = nil
+	}
+	return ret, err
+}
+
+// Checks schedule at the start of every minute, config is read every
+// time so changes apply without restart. Entry is skipped while job
+// it created before is not finished.
+func runScheduler(exitchan <-chan struct{}) {
+	for {
+		next := time.Now().Truncate(time.Minute).Add(time.Minute)
+		select {
+		case <-exitchan:
+			return
+		case <-time.After(time.Until(next)):
+		}
+		runScheduledJobs(next)
+	}
+}
+
+func runScheduledJobs(t time.Time) {
+	entries, err := loadSchedule()
+	if err != nil {
+		log.Printf("Failed to load schedule: %s", err.Error())
+		return
+	}
+	for name, e := range entries {
+		if e.Disabled {
+			continue
+		}
+		c, err := parseCron(e.Cron)
+		if err != nil {
+			log.Printf("Schedule entry %q has bad cron: %s", name, err.Error())
+			continue
+		}
+		if !c.Matches(t) {
+			continue
+		}
+		if scheduleBusy(name) {
+			log.Printf("Schedule entry %q skipped, previous run is not finished", name)
+			continue
+		}
+		params, err := json.Marshal(e.Params)
+		if err != nil {
+			log.Printf("Schedule entry %q has bad params: %s", name, err.Error())
+			continue
+		}
+		j, err := jobs.Add(e.Type, name, params)
+		if err != nil {
+			log.Printf("Schedule entry %q failed to add job: %s", name, err.Error())
+			continue
+		}
+		log.Printf("Schedule entry %q started job %d", name, j.ID)
+		pruneScheduleRuns(name)
+	}
+}
+
+func scheduleRuns(name string) []job {
+	ret := []job{}
+	for _, j := range jobs.List() {
+		if j.Schedule == name {
+			ret = append(ret, j)
+		}
+	}
+	return ret
+}
+
+func scheduleBusy(name string) bool {
+	for _, j := range scheduleRuns(name) {
+		if j.State == jobQueued || j.State == jobRunning || j.State == jobPaused {
+			return true
+		}
+	}
+	return false
+}
+
+// keeps schedule_history newest finished runs of the entry
+func pruneScheduleRuns(name string) {
+	keep := cfg.GetDSInt(20, "schedule_history")
+	runs := scheduleRuns(name)
+	finished := 0
+	for i := len(runs) - 1; i >= 0; i-- {
+		switch runs[i].State {
+		case jobDone, jobFailed, jobCanceled:
+			finished++
+			if finished > keep {
+				jobs.Remove(runs[i].ID)
+			}
+		}
+	}
+}
+
+type scheduleStatus struct {
+	Name string
+	scheduleEntry
+	// zero if entry is disabled or cron is bad
+	Next  time.Time
+	Error string `json:",omitempty"`
+	// newest first
+	Runs []job
+}
+
+func listScheduleStatus() ([]scheduleStatus, error) {
+	entries, err := loadSchedule()
+	if err != nil {
+		return nil, err
+	}
+	ret := []scheduleStatus{}
+	for name, e := range entries {
+		st := scheduleStatus{Name: name, scheduleEntry: e}
+		if c, err := parseCron(e.Cron); err != nil {
+			st.Error = err.Error()
+		} else if !e.Disabled {
+			st.Next = c.Next(time.Now())
+		}
+		runs := scheduleRuns(name)
+		for i := len(runs) - 1; i >= 0; i-- {
+			st.Runs = append(st.Runs, runs[i])
+		}
+		ret = append(ret, st)
+	}
+	sort.Slice(ret, func(i, j int) bool { return ret[i].Name < ret[j].Name })
+	return ret, nil
+}
+
+func apiListSchedule(_ http.ResponseWriter, _ *http.Request) (int, string) {
+	st, err := listScheduleStatus()
+	if err != nil {
+		return 500, err.Error()
+	}
+	return marshalOrFail(200, st)
+}
+
+func jobsHandler(w http.ResponseWriter, r *http.Request) {
+	st, err := listScheduleStatus()
+	if err != nil {
+		plainmsg(w, r, plainmsgColorRed, "Error loading schedule: "+err.Error())
+		return
+	}
+	list := jobs.List()
+	sort.Slice(list, func(i, j int) bool { return list[i].ID > list[j].ID })
+	templateRespond("jobs", w, r, map[string]any{
+		"Schedule": st,
+		"Jobs":     list,
+		"Types":    listJobTypes(),
+	})
+}
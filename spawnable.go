@@ -0,0 +1,111 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"image"
+	"image/color"
+	"time"
+
+	"github.com/maxsupermanhd/WebChunk/render"
+	"github.com/maxsupermanhd/go-vmc/v764/level/block"
+)
+
+var spawnableColor = color.NRGBA{255, 0, 0, 160}
+
+// Marks top solid blocks where hostile mobs can spawn at night: block light
+// above them is not higher than dimension limit (0 in overworld).
+// Columns without stored light data (chunks saved before light was kept) are left empty.
+func drawChunkSpawnable(d render.ChunkData) *image.RGBA {
+	t := time.Now()
+	img := image.NewRGBA(image.Rect(0, 0, 16, 16))
+	chunk := d.Get()
+	if chunk == nil {
+		return img
+	}
+	b := newChunkBlocks(chunk)
+	if len(b.sections) == 0 {
+		return img
+	}
+	light := map[int][]byte{}
+	for _, s := range chunk.Sections {
+		if len(s.BlockLight) == 2048 {
+			light[int(s.Y)] = s.BlockLight
+		}
+	}
+	limit := 0
+	if dim := d.GetDimension(); dim != nil {
+		limit = int(dim.MonsterSpawnBlockLightLimit)
+	}
+	tops := chunkColumnTops(chunk, d.GetDimension(), d.GetOptions())
+	for i := 0; i < 16*16; i++ {
+		x, z := i%16, i/16
+		y := b.maxY - 1
+		if tops != nil && tops[i] < y {
+			y = tops[i]
+		}
+		for ; y >= b.minY; y-- {
+			state := b.get(x, y, z)
+			if isAirState(state) {
+				continue
+			}
+			// plants and other see-through blocks don't stop mobs from standing in them
+			_, isTransparent, isWater := blockColor(state)
+			if isTransparent && !isWater {
+				continue
+			}
+			break
+		}
+		if y < b.minY {
+			continue
+		}
+		state := b.get(x, y, z)
+		if _, _, isWater := blockColor(state); isWater {
+			continue
+		}
+		if _, ok := block.StateList[state].(block.Lava); ok {
+			continue
+		}
+		// surfaces cut by Y cutoff have no room above
+		if above := b.get(x, y+1, z); !isAirState(above) {
+			if _, isTransparent, isWater := blockColor(above); !isTransparent || isWater {
+				continue
+			}
+		}
+		l, ok := blockLight(light, x, y+1, z)
+		if !ok || l > limit {
+			continue
+		}
+		img.Set(x, z, spawnableColor)
+	}
+	appendMetrics(time.Since(t), "spawnable")
+	return img
+}
+
+// light arrays keep half a byte per block, x and z are local to the chunk
+func blockLight(light map[int][]byte, x, y, z int) (int, bool) {
+	l, ok := light[floorDiv(y, 16)]
+	if !ok {
+		return 0, false
+	}
+	i := (y-floorDiv(y, 16)*16)*16*16 + z*16 + x
+	return int(l[i/2]>>((i%2)*4)) & 0xF, true
+}
@@ -30,9 +30,9 @@ import (
 	"image/color"
 	"image/draw"
 	"log"
+	"math"
 	"net/http"
 	"os"
-	"sort"
 	"strconv"
 	"strings"
 	_ "sync"
@@ -42,6 +42,7 @@ import (
 	"github.com/gorilla/mux"
 	"github.com/maxsupermanhd/WebChunk/chunkStorage"
 	"github.com/maxsupermanhd/WebChunk/data/biomes"
+	"github.com/maxsupermanhd/WebChunk/render"
 	"github.com/maxsupermanhd/go-vmc/v764/level"
 	"github.com/maxsupermanhd/go-vmc/v764/level/block"
 	"github.com/maxsupermanhd/go-vmc/v764/save"
@@ -177,16 +178,15 @@ func drawChunkBiomes(chunk *save.Chunk) (img *image.RGBA) {
 	return img
 }
 
-func drawChunkHeightmap(chunk *save.Chunk) (img *image.RGBA) {
+func drawChunkHeightmap(chunk *save.Chunk, dt save.DimensionType, tops []int) (img *image.RGBA) {
 	t := time.Now()
+	top := columnTopsMax(tops)
 	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
 	defaultColor := color.RGBA{0, 0, 0, 255}
 	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
+	render.SortSections(chunk)
 	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		if len(s.BlockStates.Palette) == 0 || int(s.Y)*16 > top {
 			continue
 		}
 		states := prepareSectionBlockstates(&s)
@@ -196,16 +196,22 @@ func drawChunkHeightmap(chunk *save.Chunk) (img *image.RGBA) {
 			}
 			continue
 		}
+		if len(s.BlockStates.Data) == 0 && isAirState(states.Get(0)) {
+			continue
+		}
 		for y := 15; y >= 0; y-- {
+			if int(s.Y)*16+y > top {
+				continue
+			}
 			layerImg := image.NewRGBA(image.Rect(0, 0, 16, 16))
 			for i := 16*16 - 1; i >= 0; i-- {
-				if img.At(i%16, i/16) != defaultColor {
+				if img.At(i%16, i/16) != defaultColor || aboveColumnTop(tops, i, int(s.Y)*16+y) {
 					continue
 				}
 				state := states.Get(y*16*16 + i)
 				// block := block.StateList[state]
 				if !isAirState(state) {
-					absy := uint8(int(s.Y)*16 + y)
+					absy := heightToShade(int(s.Y)*16+y, dt)
 					layerImg.Set(i%16, i/16, color.RGBA{absy, absy, 255, 255})
 				}
 			}
@@ -220,65 +226,26 @@ func drawChunkHeightmap(chunk *save.Chunk) (img *image.RGBA) {
 	return img
 }
 
-func drawShadedTerrain(chunkContext ContextedChunkData) *image.RGBA {
-	img := drawChunk(chunkContext.center)
+func drawShadedTerrain(chunkContext render.ChunkData) *image.RGBA {
+	img := drawChunk(chunkContext.Get(), chunkColumnTops(chunkContext.Get(), chunkContext.GetDimension(), chunkContext.GetOptions()), renderBiomeTinter(chunkContext))
 	sh := drawChunkShading(chunkContext)
 	draw.Draw(img, img.Rect, sh, image.Point{}, draw.Over)
 	return img
 }
 
-func drawChunkShading(chunkContext ContextedChunkData) (img *image.RGBA) {
-	t := time.Now()
-	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
-	defaultColor := color.RGBA{0, 0, 0, 0}
-	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
-	// TODO: generating heightmap must be done on storage/proxy level, not here and 3 times per chunk
-	hmc := genHeightmap(chunkContext.center)
-	var hmr []int
-	if chunkContext.right != nil {
-		hmr = genHeightmap(chunkContext.right)
-	}
-	var hmt []int
-	if chunkContext.top != nil {
-		hmt = genHeightmap(chunkContext.top)
-	}
-	for i := 0; i < 16*16; i++ {
-		hc := hmc[i]
-		ht := -1
-		hr := -1
-		if i%16 == 15 {
-			if chunkContext.right != nil {
-				hr = hmr[i-15]
-			} else {
-				img.Set(i%16, i/16, color.RGBA{255, 0, 0, 255})
-			}
-		} else {
-			hr = hmc[i+1]
-		}
-		if i < 16 {
-			if chunkContext.top != nil {
-				ht = hmt[16*15+i]
-			} else {
-				img.Set(i%16, i/16, color.RGBA{0, 255, 0, 255})
-			}
-		} else {
-			ht = hmc[i-16]
-		}
-		d := 0
-		if ht > hc {
-			d += (ht - hc) * 16
-		}
-		if hr > hc {
-			d += (hr - hc) * 16
-		}
-		if d > 64 {
-			d = 64
-		}
-		img.Set(i%16, i/16, color.RGBA{0, 0, 0, uint8(d)})
-
+// scales block height to 0-255 within dimension's build range
+func heightToShade(y int, dt save.DimensionType) uint8 {
+	if dt.Height <= 0 {
+		return uint8(y)
 	}
-	appendMetrics(time.Since(t), "shading")
-	return img
+	v := (y - int(dt.MinY)) * 256 / int(dt.Height)
+	if v < 0 {
+		v = 0
+	}
+	if v > 255 {
+		v = 255
+	}
+	return uint8(v)
 }
 
 //lint:ignore U1000 for debugging
@@ -290,22 +257,113 @@ func printColor(c color.RGBA64) string {
 
 // }
 
-func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
+// palette color of block state with hardcoded tints for grass, foliage and water
+func blockColor(state block.StateID) (toColor color.RGBA64, isTransparent, isWater bool) {
+	switch block.StateList[state].(type) {
+	// Grass tint for plains, biomeTinter replaces it with actual one
+	case block.GrassBlock:
+		toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0xFF * 257}
+	case block.Grass:
+		toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+		isTransparent = true
+	case block.TallGrass:
+		toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+		isTransparent = true
+	case block.Fern:
+		toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+		isTransparent = true
+	case block.LargeFern:
+		toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+		isTransparent = true
+	case block.PottedFern:
+		toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+		isTransparent = true
+	case block.SugarCane:
+		toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
+		isTransparent = true
+
+	// Foliage tint for plains
+	case block.OakLeaves:
+		toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
+		// isTransparent = true
+	case block.JungleLeaves:
+		toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
+		// isTransparent = true
+	case block.AcaciaLeaves:
+		toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
+		// isTransparent = true
+	case block.DarkOakLeaves:
+		toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
+		// isTransparent = true
+	case block.BirchLeaves:
+		toColor = color.RGBA64{R: 0x80 * 257, G: 0xA7 * 257, B: 0x55 * 257, A: 0xFFFF}
+		// isTransparent = true
+	case block.SpruceLeaves:
+		toColor = color.RGBA64{R: 0x61 * 257, G: 0x99 * 257, B: 0x61 * 257, A: 0xFFFF}
+		// isTransparent = true
+	case block.Vine:
+		toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
+		// isTransparent = true
+
+	// Water tint for "most biomes" lmao
+
+	case block.Water:
+		toColor = color.RGBA64{R: 0x3F * 257, G: 0x76 * 257, B: 0xE4 * 257, A: 0x30 * 257}
+		isTransparent = true
+		isWater = true
+	case block.WaterCauldron:
+		toColor = color.RGBA64{R: 0x3F * 257, G: 0x76 * 257, B: 0xE4 * 257, A: 0xFF * 257}
+	default:
+		toColor = colors[state]
+	}
+	return
+}
+
+// How much of the floor color is covered by water of given depth
+func waterOpacity(depth int) float64 {
+	return math.Min(0.5+0.05*float64(depth), 0.9)
+}
+
+// Deep water gets darker so ocean floor relief and coastlines are readable
+func waterDepthShade(depth int) float64 {
+	if depth > 32 {
+		depth = 32
+	}
+	return 1 - 0.4*float64(depth)/32
+}
+
+// Solid blocks cut exactly at the Y cutoff are darkened so open space below stands out
+const cutSurfaceShade = 0.5
+
+// Only blocks at or below column tops are drawn, nil tops draw from the surface.
+// Nil tint keeps palette colors of grass, foliage and water.
+func drawChunk(chunk *save.Chunk, tops []int, tint *biomeTinter) (img *image.RGBA) {
 	t := time.Now()
+	top := columnTopsMax(tops)
 	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
 	defaultColor := color.RGBA{0, 0, 0, 0}
 	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
 	if chunk == nil || len(chunk.Sections) == 0 {
 		return img
 	}
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
+	render.SortSections(chunk)
 	type OutputBlock struct {
 		c []color.RGBA64
 		b []block.Block
 	}
 	outputs := make([]OutputBlock, 16*16)
+	waterDepth := make([]int, 16*16)
+	// only water is darkened by depth, glass or leaves above it keep their color
+	shadeWater := func(i int) {
+		if waterDepth[i] == 0 {
+			return
+		}
+		for j, b := range outputs[i].b {
+			if _, ok := b.(block.Water); ok {
+				outputs[i].c[j] = shadeColor(outputs[i].c[j], waterDepthShade(waterDepth[i]))
+			}
+		}
+	}
 	failedState := 0
 	failedID := 0
 	colored := make([]bool, 32*32)
@@ -313,7 +371,10 @@ func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
 		colored[i] = false
 	}
 	for _, s := range chunk.Sections {
-		if len(s.BlockStates.Data) == 0 {
+		if int(s.Y)*16 > top {
+			continue
+		}
+		if len(s.BlockStates.Palette) == 0 {
 			continue
 		}
 		states := prepareSectionBlockstates(&s)
@@ -321,14 +382,21 @@ func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
 			log.Printf("Chunk %d:%d section %d has broken states pallete", chunk.XPos, chunk.YPos, s.Y)
 			continue
 		}
+		// sections filled with a single block have no data
+		if len(s.BlockStates.Data) == 0 && isAirState(states.Get(0)) {
+			continue
+		}
 		// biomes := prepareSectionBiomes(&s)
 		// if biomes == nil {
 		// 	log.Printf("Chunk %d:%d section %d has broken biome pallete", chunk.XPos, chunk.YPos, s.Y)
 		// 	continue
 		// }
 		for y := 15; y >= 0; y-- {
+			if int(s.Y)*16+y > top {
+				continue
+			}
 			for i := 16*16 - 1; i >= 0; i-- {
-				if colored[i] {
+				if colored[i] || aboveColumnTop(tops, i, int(s.Y)*16+y) {
 					continue
 				}
 				state := states.Get(y*16*16 + i)
@@ -336,71 +404,12 @@ func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
 				if isAirState(state) {
 					continue
 				}
-				toColor := color.RGBA64{R: 0, G: 0, B: 0, A: 0}
-				isTransparent := false
-				isWater := false
-				switch blockState.(type) {
-				// Grass tint for plains
-				// TODO: actually grab correct tint from biome
-				case block.GrassBlock:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0xFF * 257}
-				case block.Grass:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.TallGrass:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.Fern:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.LargeFern:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.PottedFern:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-				case block.SugarCane:
-					toColor = color.RGBA64{R: 0x91 * 257, G: 0xBD * 257, B: 0x59 * 257, A: 0x7F * 257}
-					isTransparent = true
-
-				// Foliage tint for plains
-				// TODO: actually grab correct tint from biome
-				case block.OakLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.JungleLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.AcaciaLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.DarkOakLeaves:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.BirchLeaves:
-					toColor = color.RGBA64{R: 0x80 * 257, G: 0xA7 * 257, B: 0x55 * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.SpruceLeaves:
-					toColor = color.RGBA64{R: 0x61 * 257, G: 0x99 * 257, B: 0x61 * 257, A: 0xFFFF}
-					// isTransparent = true
-				case block.Vine:
-					toColor = color.RGBA64{R: 0x77 * 257, G: 0xAB * 257, B: 0x2F * 257, A: 0xFFFF}
-					// isTransparent = true
-
-				// Water tint for "most biomes" lmao
-
-				case block.Water:
-					toColor = color.RGBA64{R: 0x3F * 257, G: 0x76 * 257, B: 0xE4 * 257, A: 0x30 * 257}
-					isTransparent = true
-					isWater = true
-				case block.WaterCauldron:
-					toColor = color.RGBA64{R: 0x3F * 257, G: 0x76 * 257, B: 0xE4 * 257, A: 0xFF * 257}
-				default:
-					toColor = colors[state]
-				}
+				toColor, isTransparent, isWater := blockColor(state)
+				toColor = tint.apply(state, toColor, i%16, int(s.Y)*16+y, i/16)
 
 				if !isTransparent {
-					if len(outputs[i].c) > 1 {
+					if len(outputs[i].c) > 1 || waterDepth[i] > 0 {
+						shadeWater(i)
 						for c1 := 1; c1 < len(outputs[i].c); c1++ {
 							cvA := float64(outputs[i].c[c1].A) / 65535
 							outputs[i].c[0].R = uint16(float64(outputs[i].c[0].R)*(1-cvA) + float64(outputs[i].c[c1].R)*cvA)
@@ -408,9 +417,16 @@ func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
 							outputs[i].c[0].B = uint16(float64(outputs[i].c[0].B)*(1-cvA) + float64(outputs[i].c[c1].B)*cvA)
 
 						}
-						toColor.R = uint16(float64(toColor.R)*0.3 + float64(outputs[i].c[0].R)*0.7)
-						toColor.G = uint16(float64(toColor.G)*0.3 + float64(outputs[i].c[0].G)*0.7)
-						toColor.B = uint16(float64(toColor.B)*0.3 + float64(outputs[i].c[0].B)*0.7)
+						k := 0.7
+						if waterDepth[i] > 0 {
+							k = waterOpacity(waterDepth[i])
+						}
+						toColor.R = uint16(float64(toColor.R)*(1-k) + float64(outputs[i].c[0].R)*k)
+						toColor.G = uint16(float64(toColor.G)*(1-k) + float64(outputs[i].c[0].G)*k)
+						toColor.B = uint16(float64(toColor.B)*(1-k) + float64(outputs[i].c[0].B)*k)
+					}
+					if tops != nil && int(s.Y)*16+y == tops[i] && len(outputs[i].c) == 0 {
+						toColor = shadeColor(toColor, cutSurfaceShade)
 					}
 					toColor.A = 65535
 					// log.Printf("Painting %02d:%02d %v %#v %#v", i%16, i/16, toColor, blockState.ID(), outputs[i].b)
@@ -418,6 +434,7 @@ func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
 					colored[i] = true
 				} else {
 					if isWater {
+						waterDepth[i]++
 						if len(outputs[i].b) < 2 {
 							outputs[i].c = append(outputs[i].c, toColor)
 							outputs[i].b = append(outputs[i].b, blockState)
@@ -430,6 +447,22 @@ func drawChunk(chunk *save.Chunk) (img *image.RGBA) {
 			}
 		}
 	}
+	// floor is not stored or cut off, only water is known
+	for i := range waterDepth {
+		if colored[i] || waterDepth[i] == 0 {
+			continue
+		}
+		shadeWater(i)
+		c := outputs[i].c[0]
+		for c1 := 1; c1 < len(outputs[i].c); c1++ {
+			cvA := float64(outputs[i].c[c1].A) / 65535
+			c.R = uint16(float64(c.R)*(1-cvA) + float64(outputs[i].c[c1].R)*cvA)
+			c.G = uint16(float64(c.G)*(1-cvA) + float64(outputs[i].c[c1].G)*cvA)
+			c.B = uint16(float64(c.B)*(1-cvA) + float64(outputs[i].c[c1].B)*cvA)
+		}
+		c.A = 65535
+		img.Set(i%16, i/16, c)
+	}
 	if failedState != 0 {
 		log.Println("Failed to lookup", failedState, "block states")
 	}
@@ -521,9 +554,7 @@ func drawChunkXray(chunk *save.Chunk) (img *image.RGBA) {
 	img = image.NewRGBA(image.Rect(0, 0, 16, 16))
 	defaultColor := color.RGBA{0, 0, 0, 0}
 	draw.Draw(img, img.Bounds(), &image.Uniform{defaultColor}, image.Point{}, draw.Src)
-	sort.Slice(chunk.Sections, func(i, j int) bool {
-		return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-	})
+	render.SortSections(chunk)
 	type OutputBlock struct {
 		sR, sG, sB, sA uint64
 		c              uint64
@@ -689,9 +720,7 @@ func terrainInfoHandler(w http.ResponseWriter, r *http.Request) {
 	}
 	bedrockInfo := ""
 	if chunk != nil && chunk.Sections != nil {
-		sort.Slice(chunk.Sections, func(i, j int) bool {
-			return int8(chunk.Sections[i].Y) > int8(chunk.Sections[j].Y)
-		})
+		render.SortSections(chunk)
 		for _, s := range chunk.Sections {
 			if len(s.BlockStates.Data) == 0 {
 				continue
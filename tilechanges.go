@@ -0,0 +1,440 @@
+/*
+	WebChunk, web server for block game maps
+	Copyright (C) 2022 Maxim Zhuchkov
+
+	This program is free software: you can redistribute it and/or modify
+	it under the terms of the GNU Affero General Public License as published
+	by the Free Software Foundation, either version 3 of the License, or
+	(at your option) any later version.
+
+	This program is distributed in the hope that it will be useful,
+	but WITHOUT ANY WARRANTY; without even the implied warranty of
+	MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+	GNU Affero General Public License for more details.
+
+	You should have received a copy of the GNU Affero General Public License
+	along with this program.  If not, see <https://www.gnu.org/licenses/>.
+
+	Contact me via mail: q3.max.2011@yandex.ru or Discord: MaX#6717
+*/
+
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
+	"github.com/maxsupermanhd/WebChunk/primitives"
+	"github.com/maxsupermanhd/lac"
+)
+
+// Every chunk write is remembered as change time of storage level tiles
+// showing it, bordering chunks are drawn by neighbour tiles too. Cached
+// image drawn before last change of any tile it covers is stale, it is
+// drawn again when requested and (with render_received) soon after the
+// change in background for cached variants listed in config. Change is
+// forgotten once every cached image covering it is drawn after it.
+
+type tileChangeDim struct {
+	World, Dimension string
+}
+
+type tileChangeRecord struct {
+	World     string
+	Dimension string
+	X, Z      int
+	ChangedAt time.Time
+}
+
+type tileChangeTracker struct {
+	lock    sync.Mutex
+	path    string
+	changes map[tileChangeDim]map[tilePos]time.Time
+	// changed since last background render
+	pending map[tileChangeDim]map[tilePos]time.Time
+	unsaved bool
+	wake    chan struct{}
+}
+
+const tileChangesSaveInterval = time.Minute
+
+// changes are checked for pruning this often, only ones older than
+// that so images drawn before them had time to be saved by cache
+const tileChangesPruneInterval = time.Hour
+
+var tileChanges = &tileChangeTracker{
+	changes: map[tileChangeDim]map[tilePos]time.Time{},
+	pending: map[tileChangeDim]map[tilePos]time.Time{},
+	wake:    make(chan struct{}, 1),
+}
+
+// MarkChunk must be called after chunk is written to storage
+func (t *tileChangeTracker) MarkChunk(world, dim string, cx, cz int) {
+	now := time.Now()
+	k := tileChangeDim{World: world, Dimension: dim}
+	t.lock.Lock()
+	if t.changes[k] == nil {
+		t.changes[k] = map[tilePos]time.Time{}
+	}
+	if t.pending[k] == nil {
+		t.pending[k] = map[tilePos]time.Time{}
+	}
+	for x := cx - 1; x <= cx+1; x++ {
+		for z := cz - 1; z <= cz+1; z++ {
+			p := tilePos{X: x >> imagecache.StorageLevel, Z: z >> imagecache.StorageLevel}
+			t.changes[k][p] = now
+			t.pending[k][p] = now
+		}
+	}
+	t.unsaved = true
+	t.lock.Unlock()
+	select {
+	case t.wake <- struct{}{}:
+	default:
+	}
+}
+
+// ChangedAt returns last change of chunks shown by tile, zero if unknown
+func (t *tileChangeTracker) ChangedAt(loc primitives.ImageLocation) time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	tiles := t.changes[tileChangeDim{World: loc.World, Dimension: loc.Dimension}]
+	if len(tiles) == 0 {
+		return time.Time{}
+	}
+	if loc.S <= imagecache.StorageLevel {
+		sl := imagecache.StorageLevelLocation(loc)
+		return tiles[tilePos{X: sl.X, Z: sl.Z}]
+	}
+	shift := loc.S - imagecache.StorageLevel
+	ret := time.Time{}
+	if n := 1 << shift; n*n > len(tiles) {
+		for p, at := range tiles {
+			if p.X>>shift == loc.X && p.Z>>shift == loc.Z && at.After(ret) {
+				ret = at
+			}
+		}
+		return ret
+	}
+	for x := loc.X << shift; x < (loc.X+1)<<shift; x++ {
+		for z := loc.Z << shift; z < (loc.Z+1)<<shift; z++ {
+			if at := tiles[tilePos{X: x, Z: z}]; at.After(ret) {
+				ret = at
+			}
+		}
+	}
+	return ret
+}
+
+// Stale tells if image of tile drawn at modTime is older than chunks it shows
+func (t *tileChangeTracker) Stale(loc primitives.ImageLocation, modTime time.Time) bool {
+	if !tileShowsChunks(loc.Variant) {
+		return false
+	}
+	return t.ChangedAt(loc).After(modTime)
+}
+
+func (t *tileChangeTracker) takePending() map[tileChangeDim]map[tilePos]time.Time {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	ret := t.pending
+	t.pending = map[tileChangeDim]map[tilePos]time.Time{}
+	return ret
+}
+
+// tiles drawn by RenderTile (grids and such) do not change with chunks
+func tileShowsChunks(variant string) bool {
+	if isCompositeVariant(variant) {
+		layers, err := parseCompositeVariant(variant)
+		if err != nil {
+			return false
+		}
+		for _, l := range layers {
+			if l.renderer.RenderTile == nil {
+				return true
+			}
+		}
+		return false
+	}
+	rr := renderers.Get(variant)
+	return rr != nil && rr.RenderTile == nil
+}
+
+func (t *tileChangeTracker) Run(exitchan <-chan struct{}) {
+	t.lock.Lock()
+	t.path = cfg.GetDSString("tileChanges.json", "tile_changes_path")
+	t.lock.Unlock()
+	if err := t.load(); err != nil {
+		log.Printf("Failed to load tile changes: %s", err.Error())
+	}
+	saveTicker := time.NewTicker(tileChangesSaveInterval)
+	defer saveTicker.Stop()
+	pruneTicker := time.NewTicker(tileChangesPruneInterval)
+	defer pruneTicker.Stop()
+	for {
+		select {
+		case <-pruneTicker.C:
+			t.prune(exitchan)
+		case <-exitchan:
+			if err := t.save(); err != nil {
+				log.Printf("Failed to save tile changes: %s", err.Error())
+			}
+			return
+		case <-saveTicker.C:
+			if err := t.save(); err != nil {
+				log.Printf("Failed to save tile changes: %s", err.Error())
+			}
+		}
+	}
+}
+
+// drops changes every cached image covering is newer than, cache
+// files are looked at without holding the lock
+func (t *tileChangeTracker) prune(exitchan <-chan struct{}) {
+	before := time.Now().Add(-tileChangesPruneInterval)
+	t.lock.Lock()
+	old := map[tileChangeDim]map[tilePos]time.Time{}
+	for k, tiles := range t.changes {
+		for p, at := range tiles {
+			if at.Before(before) {
+				if old[k] == nil {
+					old[k] = map[tilePos]time.Time{}
+				}
+				old[k][p] = at
+			}
+		}
+	}
+	t.lock.Unlock()
+	pruned := 0
+	for k, tiles := range old {
+		dirs, err := ic.CachedVariantDirs(k.World, k.Dimension)
+		if err != nil {
+			log.Printf("Failed to list cached variants of %s %s: %s", k.World, k.Dimension, err.Error())
+			continue
+		}
+		// tiles drawn by RenderTile never become stale
+		shown := map[string][]int{}
+		for _, d := range dirs {
+			v, _, _ := strings.Cut(d, "@")
+			if tileShowsChunks(v) {
+				shown[d] = ic.DirScales(k.World, k.Dimension, d)
+			}
+		}
+		for p, at := range tiles {
+			select {
+			case <-exitchan:
+				return
+			default:
+			}
+			if tileChangeCovered(k, shown, p, at) {
+				t.lock.Lock()
+				// changed again while checking
+				if t.changes[k][p].Equal(at) {
+					delete(t.changes[k], p)
+					t.unsaved = true
+					pruned++
+				}
+				t.lock.Unlock()
+			}
+		}
+	}
+	t.lock.Lock()
+	for k, tiles := range t.changes {
+		if len(tiles) == 0 {
+			delete(t.changes, k)
+		}
+	}
+	t.lock.Unlock()
+	if pruned > 0 {
+		log.Printf("Pruned %d tile changes covered by cached images", pruned)
+	}
+}
+
+// tells if no cached image of any scale showing tile p was drawn before at,
+// dirs are variant directories with scales saved in them
+func tileChangeCovered(k tileChangeDim, dirs map[string][]int, p tilePos, at time.Time) bool {
+	for d, scales := range dirs {
+		for _, s := range scales {
+			if s < imagecache.StorageLevel {
+				continue
+			}
+			shift := s - imagecache.StorageLevel
+			modTime := ic.DirTileModTime(k.World, k.Dimension, d, s, p.X>>shift, p.Z>>shift)
+			if !modTime.IsZero() && modTime.Before(at) {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (t *tileChangeTracker) load() error {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	b, err := os.ReadFile(t.path)
+	if errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	var loaded []tileChangeRecord
+	if err := json.Unmarshal(b, &loaded); err != nil {
+		return err
+	}
+	for _, r := range loaded {
+		k := tileChangeDim{World: r.World, Dimension: r.Dimension}
+		if t.changes[k] == nil {
+			t.changes[k] = map[tilePos]time.Time{}
+		}
+		p := tilePos{X: r.X, Z: r.Z}
+		if r.ChangedAt.After(t.changes[k][p]) {
+			t.changes[k][p] = r.ChangedAt
+		}
+	}
+	return nil
+}
+
+func (t *tileChangeTracker) save() error {
+	t.lock.Lock()
+	if !t.unsaved || t.path == "" {
+		t.lock.Unlock()
+		return nil
+	}
+	path := t.path
+	records := []tileChangeRecord{}
+	for k, tiles := range t.changes {
+		for p, at := range tiles {
+			records = append(records, tileChangeRecord{
+				World:     k.World,
+				Dimension: k.Dimension,
+				X:         p.X,
+				Z:         p.Z,
+				ChangedAt: at,
+			})
+		}
+	}
+	t.unsaved = false
+	t.lock.Unlock()
+	b, err := json.Marshal(records)
+	if err == nil {
+		err = os.WriteFile(path+".tmp", b, 0644)
+	}
+	if err == nil {
+		err = os.Rename(path+".tmp", path)
+	}
+	if err != nil {
+		t.lock.Lock()
+		t.unsaved = true
+		t.lock.Unlock()
+	}
+	return err
+}
+
+// Renders changed tiles of configured cached variants again shortly after
+// chunks are received, parents are put together from re-rendered
+// children scale by scale.
+func runChangedTilesRenderer(exitchan <-chan struct{}) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		<-exitchan
+		cancel()
+	}()
+	q := tileRenderPool.NewQueue()
+	defer q.Close()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-tileChanges.wake:
+		}
+		// chunks come in bursts, let them settle
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Duration(cfg.GetDSInt(5, "render_received_delay")) * time.Second):
+		}
+		pending := tileChanges.takePending()
+		if !cfg.GetDSBool(true, "render_received") {
+			continue
+		}
+		for k, tiles := range pending {
+			renderChangedTiles(ctx, q, k, tiles)
+		}
+	}
+}
+
+// variants drawn again in background, read every time so changes apply without restart
+func renderReceivedVariants() map[string]bool {
+	var names []string
+	err := cfg.GetToStruct(&names, "render_received_variants")
+	if err != nil {
+		if !errors.Is(err, lac.ErrNoKey) {
+			log.Printf("Failed to read render_received_variants: %s", err.Error())
+		}
+		names = []string{"terrain"}
+	}
+	ret := map[string]bool{}
+	for _, n := range names {
+		ret[n] = true
+	}
+	return ret
+}
+
+func renderChangedTiles(ctx context.Context, q *renderQueue, k tileChangeDim, tiles map[tilePos]time.Time) {
+	eager := renderReceivedVariants()
+	if len(eager) == 0 {
+		return
+	}
+	variants, err := ic.CachedVariants(k.World, k.Dimension)
+	if err != nil {
+		log.Printf("Failed to list cached variants of %s %s: %s", k.World, k.Dimension, err.Error())
+		return
+	}
+	for _, v := range variants {
+		if !eager[v.Variant] || !tileShowsChunks(v.Variant) {
+			continue
+		}
+		level := tiles
+		for s := imagecache.StorageLevel; len(level) > 0; s++ {
+			parents := map[tilePos]time.Time{}
+			cached := false
+			for p, at := range level {
+				pp := tilePos{X: p.X >> 1, Z: p.Z >> 1}
+				if at.After(parents[pp]) {
+					parents[pp] = at
+				}
+				loc := v
+				loc.S, loc.X, loc.Z = s, p.X, p.Z
+				modTime := ic.GetCachedImageModTime(loc)
+				if modTime.IsZero() {
+					continue
+				}
+				cached = true
+				if !modTime.Before(at) {
+					continue
+				}
+				var rerr error
+				if !q.Do(ctx, loc, func() { _, rerr = imageGetSync(loc, false) }) {
+					return
+				}
+				if rerr != nil {
+					log.Printf("Failed to render changed tile %s: %s", loc.String(), rerr.Error())
+				}
+			}
+			// parents are put together from children so they are not cached either
+			if !cached {
+				break
+			}
+			level = parents
+		}
+	}
+}
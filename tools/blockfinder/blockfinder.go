@@ -1,3 +1,5 @@
+// Deprecated: blockfinder job of the web server searches any storage with
+// block search queries, this tool is not maintained and works only with postgres.
 package main
 
 import (
@@ -26,12 +26,13 @@ func createRouter(exitchan <-chan struct{}) http.Handler {
 		w.Write([]byte("Success"))
 	}).Methods("GET")
 	router.HandleFunc("/worlds/{world}/{dim}", dimensionHandler).Methods("GET")
-	router.HandleFunc("/worlds/{world}/{dim}/tiles/{ttype}/{cs:[0-9]+}/{cx:-?[0-9]+}/{cz:-?[0-9]+}/{format}", tileRouterHandler).Methods("GET")
+	router.HandleFunc("/worlds/{world}/{dim}/tiles/{ttype}/{cs:-?[0-9]+}/{cx:-?[0-9]+}/{cz:-?[0-9]+}/{format}", tileRouterHandler).Methods("GET")
 	router.HandleFunc("/view", basicTemplateResponseHandler("view")).Methods("GET")
 	router.HandleFunc("/colors", colorsHandlerGET).Methods("GET")
 	router.HandleFunc("/colors", colorsHandlerPOST).Methods("POST")
 	router.HandleFunc("/colors/save", colorsSaveHandler).Methods("GET")
 	router.HandleFunc("/cfg", cfgHandler).Methods("GET")
+	router.HandleFunc("/jobs", jobsHandler).Methods("GET")
 
 	router.HandleFunc("/api/v1/config/save", apiHandle(apiSaveConfig)).Methods("GET")
 
@@ -49,6 +50,20 @@ func createRouter(exitchan <-chan struct{}) http.Handler {
 
 	router.HandleFunc("/api/v1/dims", apiHandle(apiAddDimension)).Methods("POST")
 	router.HandleFunc("/api/v1/dims", apiHandle(apiListDimensions)).Methods("GET")
+	router.HandleFunc("/api/v1/diff/{world}/{dim}", apiHandle(apiChunkDiffSummary)).Methods("GET")
+
+	router.HandleFunc("/api/v1/jobs", apiHandle(apiListJobs)).Methods("GET")
+	router.HandleFunc("/api/v1/jobs", apiHandle(apiAddJob)).Methods("POST")
+	router.HandleFunc("/api/v1/jobs/types", apiHandle(apiListJobTypes)).Methods("GET")
+	router.HandleFunc("/api/v1/jobs/{id:[0-9]+}", apiHandle(apiGetJob)).Methods("GET")
+	router.HandleFunc("/api/v1/jobs/{id:[0-9]+}", apiHandle(apiRemoveJob)).Methods("DELETE")
+	router.HandleFunc("/api/v1/jobs/{id:[0-9]+}/pause", apiHandle(apiJobAction(jobs.Pause))).Methods("POST")
+	router.HandleFunc("/api/v1/jobs/{id:[0-9]+}/resume", apiHandle(apiJobAction(jobs.Resume))).Methods("POST")
+	router.HandleFunc("/api/v1/jobs/{id:[0-9]+}/cancel", apiHandle(apiJobAction(jobs.Cancel))).Methods("POST")
+
+	router.HandleFunc("/api/v1/schedule", apiHandle(apiListSchedule)).Methods("GET")
+
+	router.HandleFunc("/api/v1/cache", apiHandle(apiPurgeImageCache)).Methods("DELETE")
 
 	router.HandleFunc("/api/v1/ws", wsClientHandlerWrapper(exitchan))
 
@@ -24,16 +24,17 @@ import (
 	"bytes"
 	"encoding/binary"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"image"
 	"image/png"
 	"log"
 	"net/http"
 	"sync"
-	"sync/atomic"
 	"time"
 
 	"github.com/gorilla/websocket"
+	imagecache "github.com/maxsupermanhd/WebChunk/imageCache"
 	"github.com/maxsupermanhd/WebChunk/primitives"
 	"github.com/mitchellh/mapstructure"
 )
@@ -86,7 +87,7 @@ func wsClientHandler(w http.ResponseWriter, r *http.Request, exitchan <-chan str
 
 	e <- mapEvent{
 		Action: "updateLayers",
-		Data:   listttypes(),
+		Data:   renderers.List(),
 	}
 	e <- mapEvent{
 		Action: "updateWorldsAndDims",
@@ -95,13 +96,43 @@ func wsClientHandler(w http.ResponseWriter, r *http.Request, exitchan <-chan str
 
 	eQ := make(chan error, 2)
 	wQ := make(chan wsmessage, 32)
-	var wQdidClose atomic.Bool
+	// closed when writer exits or wQ is about to be closed, render
+	// workers sending tiles give up on it instead of blocking
+	wQdone := make(chan struct{})
+	var wQdoneOnce sync.Once
+	stopWriting := func() {
+		wQdoneOnce.Do(func() { close(wQdone) })
+	}
+	// held for reading by workers sending to wQ so it is not closed under them
+	var wQlock sync.RWMutex
+	wQclosed := false
+	closeWriter := func() {
+		stopWriting()
+		wQlock.Lock()
+		if !wQclosed {
+			wQclosed = true
+			close(wQ)
+		}
+		wQlock.Unlock()
+	}
+	sendAsync := func(m wsmessage) {
+		wQlock.RLock()
+		defer wQlock.RUnlock()
+		if wQclosed {
+			return
+		}
+		select {
+		case wQ <- m:
+		case <-wQdone:
+		}
+	}
 	rQ := make(chan wsmessage, 32)
 
 	var wg sync.WaitGroup
 	wg.Add(2)
 	go func() {
 		asyncWriter(c, wQ, eQ)
+		stopWriting()
 		wg.Done()
 	}()
 	go func() {
@@ -110,6 +141,7 @@ func wsClientHandler(w http.ResponseWriter, r *http.Request, exitchan <-chan str
 	}()
 
 	subbedTiles := map[primitives.ImageLocation]bool{}
+	renderQueue := tileRenderPool.NewQueue()
 
 	asyncTileRequestor := func(loc primitives.ImageLocation) {
 		if loc.Dimension == "" || loc.World == "" {
@@ -121,20 +153,16 @@ func wsClientHandler(w http.ResponseWriter, r *http.Request, exitchan <-chan str
 				"Action": "message",
 				"Data":   fmt.Sprintf("Error rendering tile %s: %s", loc.String(), err),
 			})
-			wQ <- wsmessage{
+			sendAsync(wsmessage{
 				msgType: websocket.TextMessage,
 				msgData: b,
-			}
+			})
 			return
 		}
-		// TODO: fix time of check time of use
-		ret := marshalBinaryTileUpdate(loc, img)
-		if !wQdidClose.Load() {
-			wQ <- wsmessage{
-				msgType: websocket.BinaryMessage,
-				msgData: ret,
-			}
-		}
+		sendAsync(wsmessage{
+			msgType: websocket.BinaryMessage,
+			msgData: marshalBinaryTileUpdate(loc, img),
+		})
 	}
 
 clientLoop:
@@ -157,8 +185,8 @@ clientLoop:
 				msgData: b,
 			}
 		case err := <-eQ:
-			wQdidClose.Store(true)
-			close(wQ)
+			renderQueue.Close()
+			closeWriter()
 			c.Close()
 			if err == nil {
 				log.Printf("Websocket %s disconnected with nil err", r.RemoteAddr)
@@ -191,8 +219,7 @@ clientLoop:
 				}
 				switch msg.Action {
 				case "tileSubscribe":
-					var loc primitives.ImageLocation
-					err := mapstructure.Decode(msg.Data, &loc)
+					loc, err := decodeTileLocation(msg.Data)
 					if err != nil {
 						log.Printf("Websocket %s sent malformed tile sub: %s", r.RemoteAddr, err.Error())
 						break
@@ -204,14 +231,14 @@ clientLoop:
 						subbedTiles[loc] = true
 						log.Printf("Websocket %s tileSub %s", r.RemoteAddr, loc)
 					}
-					go asyncTileRequestor(loc)
+					renderQueue.Go(loc, func() { asyncTileRequestor(loc) })
 				case "tileUnsubscribe":
-					var loc primitives.ImageLocation
-					err := mapstructure.Decode(msg.Data, &loc)
+					loc, err := decodeTileLocation(msg.Data)
 					if err != nil {
 						log.Printf("Websocket %s sent malformed tile unsub: %s", r.RemoteAddr, err.Error())
 						break
 					}
+					renderQueue.Cancel(loc)
 					_, ok := subbedTiles[loc]
 					if ok {
 						delete(subbedTiles, loc)
@@ -238,10 +265,12 @@ clientLoop:
 					oldSubbed := subbedTiles
 					subbedTiles = map[primitives.ImageLocation]bool{}
 					for k := range oldSubbed {
+						renderQueue.Cancel(k)
 						k.World = nWorld
 						k.Dimension = nDimension
 						subbedTiles[k] = true
-						go asyncTileRequestor(k)
+						loc := k
+						renderQueue.Go(loc, func() { asyncTileRequestor(loc) })
 					}
 				default:
 					log.Printf("Websocket %s wrong action %#+v", r.RemoteAddr, msg.Action)
@@ -252,6 +281,8 @@ clientLoop:
 		}
 	}
 	log.Printf("Websocket %s loop exited", r.RemoteAddr)
+	renderQueue.Close()
+	closeWriter()
 
 	wg.Wait()
 	log.Printf("Websocket handler %s exited", r.RemoteAddr)
@@ -263,18 +294,67 @@ var (
 	}
 )
 
+// Y cutoff is optional and only taken into account when present in the data
+func decodeTileLocation(data any) (primitives.ImageLocation, error) {
+	var loc primitives.ImageLocation
+	err := mapstructure.Decode(data, &loc)
+	if err != nil {
+		return loc, err
+	}
+	if m, ok := data.(map[string]any); ok {
+		y, ok := m["Y"]
+		loc.HasY = ok && y != nil
+	}
+	if loc.S < imagecache.MinScale {
+		return loc, errors.New("scale is too small")
+	}
+	if loc.Query != "" {
+		loc.Query, err = normalizeBlockQuery(loc.Query)
+		if err != nil {
+			return loc, err
+		}
+	}
+	return normalizeImageLocation(loc), nil
+}
+
+// Tiles with since, query, hillshade or age window are sent as 0x03 carrying
+// all parameters so client can tell updates of old subscriptions apart
 func marshalBinaryTileUpdate(loc primitives.ImageLocation, img *image.RGBA) []byte {
 	buf := bytes.NewBuffer([]byte{})
-	binary.Write(buf, binary.BigEndian, uint8(0x01))
+	params := loc.Since != 0 || loc.Query != "" || loc.Hillshade != (primitives.Hillshade{}) || loc.AgeHours != 0
+	if params {
+		binary.Write(buf, binary.BigEndian, uint8(0x03))
+	} else if loc.HasY {
+		binary.Write(buf, binary.BigEndian, uint8(0x02))
+	} else {
+		binary.Write(buf, binary.BigEndian, uint8(0x01))
+	}
 	binary.Write(buf, binary.BigEndian, uint32(len(loc.World)))
 	buf.WriteString(loc.World)
 	binary.Write(buf, binary.BigEndian, uint32(len(loc.Dimension)))
 	buf.WriteString(loc.Dimension)
 	binary.Write(buf, binary.BigEndian, uint32(len(loc.Variant)))
 	buf.WriteString(loc.Variant)
-	binary.Write(buf, binary.BigEndian, uint8(loc.S))
+	binary.Write(buf, binary.BigEndian, int8(loc.S))
 	binary.Write(buf, binary.BigEndian, int32(loc.X))
 	binary.Write(buf, binary.BigEndian, int32(loc.Z))
+	if params {
+		hasY := uint8(0)
+		if loc.HasY {
+			hasY = 1
+		}
+		binary.Write(buf, binary.BigEndian, hasY)
+		binary.Write(buf, binary.BigEndian, int32(loc.Y))
+		binary.Write(buf, binary.BigEndian, loc.Since)
+		binary.Write(buf, binary.BigEndian, uint32(len(loc.Query)))
+		buf.WriteString(loc.Query)
+		binary.Write(buf, binary.BigEndian, int32(loc.Hillshade.Azimuth))
+		binary.Write(buf, binary.BigEndian, int32(loc.Hillshade.Altitude))
+		binary.Write(buf, binary.BigEndian, loc.Hillshade.Exaggeration)
+		binary.Write(buf, binary.BigEndian, int32(loc.AgeHours))
+	} else if loc.HasY {
+		binary.Write(buf, binary.BigEndian, int32(loc.Y))
+	}
 	if img != nil {
 		pngEncoder.Encode(buf, img)
 	}